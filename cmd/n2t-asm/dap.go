@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/dap"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/debug"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+)
+
+// dapMain implements the dap subcommand, a Debug Adapter Protocol server
+// speaking over stdin and stdout.
+func dapMain(args []string) int {
+	fs := flag.NewFlagSet("dap", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm dap")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	s := &dap.Server{
+		Load: func(path string) (*debug.Session, error) {
+			var diags diag.List
+			s, err := session(path, &diags)
+			if err != nil && diags.HasErrors() {
+				diags.Sort()
+				return nil, diags
+			}
+			return s, err
+		},
+	}
+	if err := s.Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/debug"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/listing"
+)
+
+// debugMain implements the debug subcommand, an interactive debugger reading
+// commands from stdin.
+func debugMain(args []string) int {
+	fs := flag.NewFlagSet("debug", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm debug program.asm")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	var diags diag.List
+	s, err := session(fs.Arg(0), &diags)
+	diags.Sort()
+	diags.Print(os.Stderr)
+	if err != nil {
+		if !diags.HasErrors() {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return 1
+	}
+	if err := s.REPL(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// session assembles the program at path for debugging, reporting problems to
+// diags.
+func session(path string, diags *diag.List) (*debug.Session, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	program, text, symbols, err := build([]listing.Source{{Name: path, Text: string(src)}}, diags)
+	if err != nil {
+		return nil, err
+	}
+	return debug.New(program, text, symbols, listing.Lines(string(src)))
+}
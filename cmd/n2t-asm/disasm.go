@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/disasm"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/hack"
+)
+
+// disasmMain implements the disasm subcommand, printing assembly for a
+// .hack file given as the single argument or on stdin.
+func disasmMain(args []string) int {
+	fs := flag.NewFlagSet("disasm", flag.ExitOnError)
+	symFile := fs.String("sym", "", "restore label and variable names from the text symbol table in `file`")
+	hex := fs.Bool("hex", false, "write addresses in hexadecimal")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm disasm [flags] [program.hack]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if fs.NArg() == 1 {
+		f, err := os.Open(fs.Arg(0))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	} else if fs.NArg() > 1 {
+		fs.Usage()
+		return 1
+	}
+
+	var symbols *assembler.SymbolTable
+	if *symFile != "" {
+		f, err := os.Open(*symFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		symbols, err = assembler.ReadText(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *symFile, err)
+			return 1
+		}
+	}
+
+	words, err := hack.Read(r)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	program, err := disasm.Disassemble(words, symbols)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if *hex {
+		disasm.Hex(program)
+	}
+	if err := disasm.Write(os.Stdout, program); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diff"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/format"
+)
+
+// fmtMain implements the fmt subcommand, formatting files in canonical
+// layout like gofmt.
+func fmtMain(args []string) int {
+	fs := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := fs.Bool("w", false, "write the result to the source file instead of stdout")
+	showDiff := fs.Bool("d", false, "print diffs instead of the formatted source")
+	list := fs.Bool("l", false, "list files whose formatting differs")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm fmt [flags] [program.asm ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "cannot use -w with stdin")
+			return 1
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := formatFile("<stdin>", src, false, *showDiff, *list); err != nil {
+			return 1
+		}
+		return 0
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err == nil {
+			err = formatFile(path, src, *write, *showDiff, *list)
+		}
+		if err != nil {
+			status = 1
+		}
+	}
+	return status
+}
+
+// formatFile formats src, printing problems to stderr.
+func formatFile(path string, src []byte, write, showDiff, list bool) error {
+	out, err := format.Source(path, src)
+	if err != nil {
+		if diags, ok := err.(diag.List); ok {
+			diags.Print(os.Stderr)
+		} else {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		return err
+	}
+
+	changed := !bytes.Equal(src, out)
+	if list && changed {
+		fmt.Println(path)
+	}
+	if write && changed {
+		info, err := os.Stat(path)
+		if err == nil {
+			err = ioutil.WriteFile(path, out, info.Mode().Perm())
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+	}
+	if showDiff && changed {
+		fmt.Printf("diff %s.orig %s\n", path, path)
+		fmt.Print(diff.Unified(path+".orig", path, string(src), string(out)))
+	}
+	if !list && !write && !showDiff {
+		os.Stdout.Write(out)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/link"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
+)
+
+// linkMain implements the link subcommand, linking object files compiled
+// with -c into a program.
+func linkMain(args []string) int {
+	fs := flag.NewFlagSet("link", flag.ExitOnError)
+	outFile := fs.String("o", "", "write the program to `file` instead of stdout")
+	symFile := fs.String("sym", "", "write the symbol table to `file`")
+	symFormat := fs.String("sym-format", "text", "symbol table `format`, text or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm link [flags] module.o...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || (*symFormat != "text" && *symFormat != "json") {
+		fs.Usage()
+		return 1
+	}
+
+	objects := make([]*object.Object, fs.NArg())
+	for i, path := range fs.Args() {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		objects[i], err = object.Read(f)
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+			return 1
+		}
+	}
+
+	var diags diag.List
+	words, symbols, err := link.Link(objects, &diags)
+	diags.Sort()
+	diags.Print(os.Stderr)
+	if err != nil {
+		return 1
+	}
+
+	if *symFile != "" {
+		write := symbols.WriteText
+		if *symFormat == "json" {
+			write = symbols.WriteJSON
+		}
+		if err := writeFile(*symFile, write); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	writeProgram := func(w io.Writer) error {
+		for _, s := range words {
+			if _, err := fmt.Fprintln(w, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if *outFile != "" {
+		err = writeFile(*outFile, writeProgram)
+	} else {
+		err = writeProgram(os.Stdout)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lsp"
+)
+
+// lspMain implements the lsp subcommand, a Language Server Protocol server
+// speaking over stdin and stdout.
+func lspMain(args []string) int {
+	fs := flag.NewFlagSet("lsp", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm lsp")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 1
+	}
+
+	if err := (&lsp.Server{}).Serve(os.Stdin, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
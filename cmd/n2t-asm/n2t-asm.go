@@ -1,56 +1,270 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
-	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/listing"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/preproc"
 )
 
+var (
+	symFile     = flag.String("sym", "", "write the symbol table to `file`")
+	symFormat   = flag.String("sym-format", "text", "symbol table `format`, text or json")
+	lstFile     = flag.String("lst", "", "write a listing of source lines, ROM addresses and machine code to `file`")
+	lstHex      = flag.Bool("lst-hex", false, "write ROM addresses in the listing in hexadecimal")
+	canonical   = flag.Bool("canonical", false, "warn about computations not spelled canonically, such as A+D for D+A")
+	compileOnly = flag.Bool("c", false, "compile each file to a relocatable object file.o, for link, instead of assembling")
+	includes    dirList
+	defines     = defineList{}
+)
+
+func init() {
+	flag.Var(&includes, "I", "search `dir` for included files, may be repeated")
+	flag.Var(defines, "D", "define the constant `NAME=VALUE`, as if by .equ, may be repeated")
+}
+
+// dirList is a flag.Value collecting repeated directory flags
+type dirList []string
+
+func (d *dirList) String() string {
+	return strings.Join(*d, string(os.PathListSeparator))
+}
+
+func (d *dirList) Set(s string) error {
+	*d = append(*d, s)
+	return nil
+}
+
+// defineList is a flag.Value collecting repeated NAME=VALUE constants
+type defineList map[string]int
+
+func (d defineList) String() string {
+	var s []string
+	for name, value := range d {
+		s = append(s, name+"="+strconv.Itoa(value))
+	}
+	sort.Strings(s)
+	return strings.Join(s, ",")
+}
+
+func (d defineList) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("expected NAME=VALUE, got %q", s)
+	}
+	value, err := expr.Literal(s[i+1:])
+	if err != nil || value < 0 || value > command.MaxAddress {
+		return fmt.Errorf("value of %s must be a number 0..%d, got %q", s[:i], command.MaxAddress, s[i+1:])
+	}
+	d[s[:i]] = value
+	return nil
+}
+
+// commands are the subcommands, selected by the first argument
+var commands = map[string]func(args []string) int{
+	"dap":    dapMain,
+	"debug":  debugMain,
+	"disasm": disasmMain,
+	"fmt":    fmtMain,
+	"link":   linkMain,
+	"lsp":    lspMain,
+	"run":    runMain,
+	"test":   testMain,
+	"vet":    vetMain,
+}
+
 func usage() {
-	fmt.Println("Provide asm via single filename argument or stdin")
+	fmt.Fprintln(flag.CommandLine.Output(), "Provide asm via filename arguments, assembled as one program, or stdin")
+	flag.PrintDefaults()
+	fmt.Fprintln(flag.CommandLine.Output(), "\nSubcommands:\n  dap\tserve the Debug Adapter Protocol on stdio\n  debug\tstep through a program interactively\n  disasm\tdisassemble a .hack file\n  fmt\tformat assembly in canonical layout\n  link\tlink object files compiled with -c into a program\n  lsp\tserve the Language Server Protocol on stdio\n  run\texecute a program on a simulated Hack CPU\n  test\trun a .tst test script and compare against its .cmp file\n  vet\treport likely mistakes")
 }
 
 func main() {
-	var r io.Reader
-
-	if len(os.Args) == 1 {
-		r = os.Stdin
-	} else if len(os.Args) == 2 {
-		fr, err := os.Open(os.Args[1])
-		if err != nil {
-			panic(err)
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
 		}
-		defer fr.Close()
-		r = fr
-	} else {
+	}
+
+	flag.Usage = usage
+	flag.Parse()
+
+	if *symFormat != "text" && *symFormat != "json" {
 		usage()
 		os.Exit(1)
 	}
 
-	run(r)
+	var sources []listing.Source
+	if flag.NArg() == 0 {
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sources = append(sources, listing.Source{Name: "<stdin>", Text: string(src)})
+	}
+	for _, name := range flag.Args() {
+		src, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		sources = append(sources, listing.Source{Name: name, Text: string(src)})
+	}
+
+	if *compileOnly {
+		if err := compile(sources); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+	if err := run(sources); err != nil {
+		os.Exit(1)
+	}
 }
 
-func run(r io.Reader) {
-	tokens, err := lex.Tokenize(r)
+// run assembles sources to stdout, printing all diagnostics to stderr.
+func run(sources []listing.Source) error {
+	var diags diag.List
+	defer func() {
+		diags.Sort()
+		diags.Print(os.Stderr)
+	}()
+
+	program, text, symbols, err := build(sources, &diags)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
-	program, err := parser.Parse(tokens)
-	if err != nil {
-		panic(err)
+	if *symFile != "" {
+		if err := writeSymbols(*symFile, symbols); err != nil {
+			diags.Add(err)
+			return err
+		}
 	}
 
-	text, err := assembler.Assemble(program)
-	if err != nil {
-		panic(err)
+	if *lstFile != "" {
+		err := writeFile(*lstFile, func(w io.Writer) error {
+			return listing.Options{Hex: *lstHex}.WriteFiles(w, sources, program, text)
+		})
+		if err != nil {
+			diags.Add(err)
+			return err
+		}
 	}
 
 	for _, s := range text {
 		fmt.Println(s)
 	}
+	return nil
+}
+
+// build assembles sources as one program, reporting problems to diags.
+func build(sources []listing.Source, diags *diag.List) (command.Program, []string, *assembler.SymbolTable, error) {
+	program, err := parse(sources, diags)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	text, symbols, err := assembler.Options{WarnNonCanonical: *canonical, Defines: defines}.Assemble(program, diags)
+	return program, text, symbols, err
+}
+
+// parse expands, tokenizes and parses sources as one program, reporting
+// problems to diags. The error is only non-nil if a file can't be read, as
+// parsing carries on after errors so a single run reports every bad line.
+func parse(sources []listing.Source, diags *diag.List) (command.Program, error) {
+	files := make([]preproc.File, len(sources))
+	for i, s := range sources {
+		files[i] = preproc.File{Name: s.Name, R: strings.NewReader(s.Text)}
+	}
+	tokens, err := preproc.Options{Path: includes}.Tokenize(files, diags)
+	if _, ok := err.(diag.List); err != nil && !ok {
+		// read error, nothing more to report
+		diags.Add(err)
+		return nil, err
+	}
+	program, _ := parser.Parse(tokens, diags)
+	return program, nil
+}
+
+// compile assembles each source as a module, writing its object next to it
+// as name.o, or to stdout for stdin, printing all diagnostics to stderr.
+func compile(sources []listing.Source) error {
+	var diags diag.List
+	defer func() {
+		diags.Sort()
+		diags.Print(os.Stderr)
+	}()
+
+	var failed error
+	for _, src := range sources {
+		if err := compileModule(src, &diags); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+// compileModule compiles src and writes its object next to it, or to stdout
+// for stdin, reporting problems to diags.
+func compileModule(src listing.Source, diags *diag.List) error {
+	// the module's own diagnostics decide whether it compiled
+	var d diag.List
+	defer func() { diags.Add(d) }()
+
+	program, err := parse([]listing.Source{src}, &d)
+	if err != nil {
+		return err
+	}
+	obj, err := assembler.Options{WarnNonCanonical: *canonical, Defines: defines}.Compile(module(src.Name), program, &d)
+	if err != nil {
+		return err
+	}
+	if src.Name == "<stdin>" {
+		err = obj.Write(os.Stdout)
+	} else {
+		err = writeFile(strings.TrimSuffix(src.Name, filepath.Ext(src.Name))+".o", obj.Write)
+	}
+	d.Add(err)
+	return err
+}
+
+// module names the module compiled from path, its base name without extension
+func module(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// writeSymbols writes the symbol table to path in the -sym-format format.
+func writeSymbols(path string, symbols *assembler.SymbolTable) error {
+	if *symFormat == "json" {
+		return writeFile(path, symbols.WriteJSON)
+	}
+	return writeFile(path, symbols.WriteText)
+}
+
+// writeFile creates path and writes it with write.
+func writeFile(path string, write func(io.Writer) error) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	err = write(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
 }
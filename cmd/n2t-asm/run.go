@@ -0,0 +1,163 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cpu"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/hack"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/listing"
+)
+
+// runMain implements the run subcommand, executing a program until it halts
+// or runs out of cycles, then dumping RAM.
+func runMain(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	cycles := fs.Int("cycles", 1000000, "stop after `n` cycles if the program hasn't halted")
+	dump := fs.String("dump", "0-15", "comma separated RAM `ranges` to print, as addresses, symbols or first-last")
+	set := fs.String("set", "", "comma separated `addr=value` RAM cells to set before running")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm run [flags] program.asm|program.hack")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	words, symbols, err := load(fs.Arg(0))
+	if err != nil {
+		return 1
+	}
+	c, err := cpu.New(words)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *set != "" {
+		for _, s := range strings.Split(*set, ",") {
+			kv := strings.SplitN(s, "=", 2)
+			if len(kv) != 2 {
+				fmt.Fprintf(os.Stderr, "bad -set %q, expected addr=value\n", s)
+				return 1
+			}
+			addr, err := address(kv[0], symbols)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			v, err := strconv.ParseInt(kv[1], 0, 32)
+			if err != nil || v < -32768 || v > 65535 {
+				fmt.Fprintf(os.Stderr, "bad -set value %q\n", kv[1])
+				return 1
+			}
+			c.RAM[addr] = uint16(v)
+		}
+	}
+
+	ranges, err := parseRanges(*dump, symbols)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if c.Run(*cycles) {
+		fmt.Printf("halted after %d cycles at PC=%d\n", c.Cycles, c.PC)
+	} else {
+		fmt.Printf("stopped after %d cycles at PC=%d\n", c.Cycles, c.PC)
+	}
+	for _, r := range ranges {
+		for a := r[0]; a <= r[1]; a++ {
+			fmt.Printf("RAM[%d] = %d\n", a, int16(c.RAM[a]))
+		}
+	}
+	return 0
+}
+
+// load a program from a .hack file, or by assembling any other file. The
+// symbol table is nil for .hack files. Problems are printed to stderr.
+func load(path string) ([]uint16, *assembler.SymbolTable, error) {
+	if filepath.Ext(path) == ".hack" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return nil, nil, err
+		}
+		defer f.Close()
+		words, err := hack.Read(f)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", path, err)
+		}
+		return words, nil, err
+	}
+
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, nil, err
+	}
+	var diags diag.List
+	_, text, symbols, err := build([]listing.Source{{Name: path, Text: string(src)}}, &diags)
+	diags.Sort()
+	diags.Print(os.Stderr)
+	if err != nil {
+		return nil, nil, err
+	}
+	words, err := hack.Words(text)
+	return words, symbols, err
+}
+
+// parseRanges parses comma separated addresses, symbols and first-last
+// ranges of RAM.
+func parseRanges(s string, symbols *assembler.SymbolTable) ([][2]int, error) {
+	var ranges [][2]int
+	if s == "" {
+		return ranges, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := address(bounds[0], symbols)
+		if err != nil {
+			return nil, err
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = address(bounds[1], symbols); err != nil {
+				return nil, err
+			}
+		}
+		if last < first {
+			return nil, fmt.Errorf("bad RAM range %q", part)
+		}
+		ranges = append(ranges, [2]int{first, last})
+	}
+	return ranges, nil
+}
+
+// address resolves a RAM address given as a number or symbol name.
+func address(s string, symbols *assembler.SymbolTable) (int, error) {
+	s = strings.TrimSpace(s)
+	if v, err := strconv.ParseInt(s, 0, 32); err == nil {
+		if v < 0 || v >= cpu.RAMSize {
+			return 0, fmt.Errorf("RAM address %s out of range", s)
+		}
+		return int(v), nil
+	}
+	if symbols == nil {
+		symbols = assembler.NewSymbolTable()
+	}
+	sym, ok := symbols.Lookup(s)
+	if !ok {
+		return 0, fmt.Errorf("unknown RAM address or symbol %q", s)
+	}
+	return sym.Value, nil
+}
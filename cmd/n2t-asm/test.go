@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/tst"
+)
+
+// testMain implements the test subcommand, running a nand2tetris .tst script
+// and comparing its output to the script's .cmp file.
+func testMain(args []string) int {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	cycles := fs.Int("cycles", tst.DefaultMaxCycles, "fail a repeat without a count, or a while, still running after `n` cycles")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm test script.tst")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 1
+	}
+
+	r := &tst.Runner{
+		Load: func(path string) ([]uint16, error) {
+			words, _, err := load(path)
+			return words, err
+		},
+		Echo:      os.Stdout,
+		MaxCycles: *cycles,
+	}
+	if err := r.RunFile(fs.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println("End of script - Comparison ended successfully")
+	return 0
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/listing"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/vet"
+)
+
+// vetMain implements the vet subcommand, reporting likely mistakes. Like go
+// vet, naming checks with -check runs only those, and -check=false skips them.
+func vetMain(args []string) int {
+	fs := flag.NewFlagSet("vet", flag.ExitOnError)
+	enabled := map[*vet.Check]*bool{}
+	for _, c := range vet.Checks {
+		enabled[c] = fs.Bool(c.Name, true, "report "+c.Doc)
+	}
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: n2t-asm vet [flags] program.asm ...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 1
+	}
+
+	// if any check is explicitly enabled, run only those
+	only := false
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+		only = only || f.Value.String() == "true"
+	})
+	var checks []*vet.Check
+	for _, c := range vet.Checks {
+		if *enabled[c] && (!only || set[c.Name]) {
+			checks = append(checks, c)
+		}
+	}
+
+	status := 0
+	for _, path := range fs.Args() {
+		src, err := ioutil.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		var diags diag.List
+		program, _, symbols, err := build([]listing.Source{{Name: path, Text: string(src)}}, &diags)
+		if err != nil {
+			diags.Sort()
+			diags.Print(os.Stderr)
+			status = 1
+			continue
+		}
+		// vet repeats the assembler's warnings as checks
+		var found diag.List
+		vet.Run(program, symbols, checks, &found)
+		found.Sort()
+		found.Print(os.Stderr)
+		if len(found) > 0 {
+			status = 1
+		}
+	}
+	return status
+}
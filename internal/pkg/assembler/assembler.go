@@ -2,13 +2,22 @@ package assembler
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
-// table is the symbol table type
-type table map[string]int
+// RAM for variables starts at VarStart, after R0..R15, and ends before
+// VarEnd, SCREEN.
+const (
+	VarStart = 0x0010
+	VarEnd   = 0x4000
+)
 
 // static lookup tables from command string to instruction partial values
 var (
@@ -22,6 +31,7 @@ var (
 		"JMP": 0b111,
 	}
 
+	// comp has the canonical spelling of each computation, see Canonical
 	comp = map[string]int{
 		"0":   0b0101010,
 		"1":   0b0111111,
@@ -41,21 +51,12 @@ var (
 		"A-D": 0b0000111,
 		"D&A": 0b0000000,
 		"D|A": 0b0010101,
-		"_a":  0b1101010,
-		"_b":  0b1111111,
-		"_c":  0b1111010,
-		"_d":  0b1001100,
 		"M":   0b1110000,
-		"_e":  0b1001101,
 		"!M":  0b1110001,
-		"_f":  0b1001111,
 		"-M":  0b1110011,
-		"_g":  0b1011111,
 		"M+1": 0b1110111,
-		"_h":  0b1001110,
 		"M-1": 0b1110010,
 		"D+M": 0b1000010,
-		"M+D": 0b1000010,
 		"D-M": 0b1010011,
 		"M-D": 0b1000111,
 		"D&M": 0b1000000,
@@ -63,94 +64,428 @@ var (
 	}
 )
 
-// Assemble commands into HACK machine language.
-func Assemble(program command.Program) ([]string, error) {
-	symbols, err := build(program)
-	if err != nil {
-		return []string{}, fmt.Errorf("build symbols: %v", err)
+// aluFlags name the comp bits, most significant first
+var aluFlags = []string{"a", "zx", "nx", "zy", "ny", "f", "no"}
+
+// inverse lookup tables from instruction partial values to mnemonics
+var (
+	jumpNames = inverse(jump)
+	compNames = inverse(comp)
+)
+
+// CompMnemonic returns the canonical mnemonic for the 7 comp bits of a C
+// instruction, a followed by c1..c6.
+func CompMnemonic(bits int) (string, bool) {
+	s, ok := compNames[bits]
+	return s, ok
+}
+
+// JumpMnemonic returns the mnemonic for the 3 jump bits of a C instruction,
+// which is empty for no jump.
+func JumpMnemonic(bits int) (string, bool) {
+	if bits == 0 {
+		return "", true
 	}
+	s, ok := jumpNames[bits]
+	return s, ok
+}
 
-	instructions, err := assemble(program, symbols)
-	if err != nil {
-		return []string{}, fmt.Errorf("assemble second pass: %v", err)
+// Canonical returns the canonical spelling of a computation, accepting the
+// operands of +, & and | in either order, e.g. A+D for D+A.
+func Canonical(c string) (string, bool) {
+	if _, ok := comp[c]; ok {
+		return c, true
+	}
+	for _, op := range "+&|" {
+		if i := strings.IndexRune(c, op); i > 0 {
+			swapped := c[i+1:] + string(op) + c[:i]
+			if _, ok := comp[swapped]; ok {
+				return swapped, true
+			}
+		}
 	}
+	return "", false
+}
+
+// CompBits returns the 7 comp bits of a computation, spelled as a mnemonic
+// in any operand order or given as raw control bits, see IsRaw.
+func CompBits(c string) (int, bool) {
+	if IsRaw(c) {
+		bits, err := rawBits(c)
+		return bits, err == nil
+	}
+	canonical, ok := Canonical(c)
+	return comp[canonical], ok
+}
 
-	return instructions, nil
+// IsRaw returns true if a computation is given as ALU control bits, either
+// %bbbbbbb or alu(flag=bit,...) with the flags a, zx, nx, zy, ny, f and no.
+func IsRaw(c string) bool {
+	return strings.HasPrefix(c, "%") || strings.HasPrefix(c, "alu(")
+}
+
+// ReadsM returns true if a computation reads RAM[A].
+func ReadsM(c string) bool {
+	bits, ok := CompBits(c)
+	if !ok {
+		return strings.ContainsRune(c, 'M')
+	}
+	return bits&0b1000000 != 0 && bits&0b0001000 == 0
+}
+
+// UsesA returns true if a computation uses A itself as a value.
+func UsesA(c string) bool {
+	bits, ok := CompBits(c)
+	if !ok {
+		return strings.ContainsRune(c, 'A')
+	}
+	return bits&0b1000000 == 0 && bits&0b0001000 == 0
+}
+
+// rawBits decodes raw control bits, omitted alu flags are 0
+func rawBits(c string) (int, error) {
+	if strings.HasPrefix(c, "%") {
+		b := c[1:]
+		if len(b) != len(aluFlags) || strings.Trim(b, "01") != "" {
+			return 0, fmt.Errorf("control bits %s must be %d binary digits", c, len(aluFlags))
+		}
+		bits, _ := strconv.ParseUint(b, 2, 8)
+		return int(bits), nil
+	}
+	if !strings.HasSuffix(c, ")") {
+		return 0, fmt.Errorf("missing ) in %s", c)
+	}
+	bits := 0
+	seen := map[string]bool{}
+	args := strings.TrimSpace(c[len("alu(") : len(c)-1])
+	if args == "" {
+		return 0, nil
+	}
+	for _, arg := range strings.Split(args, ",") {
+		kv := strings.SplitN(arg, "=", 2)
+		name := strings.TrimSpace(kv[0])
+		if len(kv) != 2 {
+			return 0, fmt.Errorf("expected flag=0 or flag=1, got %s", strings.TrimSpace(arg))
+		}
+		i := flagIndex(name)
+		if i == -1 {
+			return 0, fmt.Errorf("unknown alu flag %s, must be one of %s", name, strings.Join(aluFlags, ", "))
+		}
+		if seen[name] {
+			return 0, fmt.Errorf("duplicate alu flag %s", name)
+		}
+		seen[name] = true
+		switch strings.TrimSpace(kv[1]) {
+		case "0":
+		case "1":
+			bits |= 1 << (len(aluFlags) - 1 - i)
+		default:
+			return 0, fmt.Errorf("alu flag %s must be 0 or 1, got %s", name, strings.TrimSpace(kv[1]))
+		}
+	}
+	return bits, nil
+}
+
+// flagIndex returns the position of an alu flag in aluFlags, or -1
+func flagIndex(name string) int {
+	for i, f := range aluFlags {
+		if f == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// CompMnemonics returns the canonical spelling of every computation, sorted.
+func CompMnemonics() []string {
+	return mnemonics(comp)
+}
+
+// JumpMnemonics returns every jump mnemonic, sorted.
+func JumpMnemonics() []string {
+	return mnemonics(jump)
+}
+
+// Options change how programs are assembled.
+type Options struct {
+	// WarnNonCanonical warns about computations not spelled canonically
+	WarnNonCanonical bool
+	// Defines are constants defined before the program, as if by .equ
+	Defines map[string]int
+}
+
+// Assemble commands into HACK machine language with default Options.
+func Assemble(program command.Program, diags *diag.List) ([]string, *SymbolTable, error) {
+	return Options{}.Assemble(program, diags)
+}
+
+// Assemble commands into HACK machine language, returning the instructions
+// along with the completed symbol table. Problems are reported to diags, the
+// returned error is non-nil if diags holds any errors.
+func (o Options) Assemble(program command.Program, diags *diag.List) ([]string, *SymbolTable, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	symbols := o.build(program, diags)
+	CheckLabelUse(program, symbols, diags)
+	linkage(program, symbols, false, diags)
+	instructions := o.assemble(program, symbols, diags)
+	if err := diags.Err(); err != nil {
+		return []string{}, symbols, err
+	}
+	return instructions, symbols, nil
 }
 
 // build symbol table
-func build(program command.Program) (table, error) {
-	symbols := table{
-		"SP":     0x0000,
-		"LCL":    0x0001,
-		"ARG":    0x0002,
-		"THIS":   0x0003,
-		"THAT":   0x0004,
-		"R0":     0x0000,
-		"R1":     0x0001,
-		"R2":     0x0002,
-		"R3":     0x0003,
-		"R4":     0x0004,
-		"R5":     0x0005,
-		"R6":     0x0006,
-		"R7":     0x0007,
-		"R8":     0x0008,
-		"R9":     0x0009,
-		"R10":    0x00a,
-		"R11":    0x00b,
-		"R12":    0x00c,
-		"R13":    0x00d,
-		"R14":    0x00e,
-		"R15":    0x00f,
-		"SCREEN": 0x4000,
-		"KBD":    0x6000,
-	}
-
-	// pass one, add labels to symbol table
+func (o Options) build(program command.Program, diags *diag.List) *SymbolTable {
+	symbols := NewSymbolTable()
+	names := make([]string, 0, len(o.Defines))
+	for name := range o.Defines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		defineConstant(symbols, command.Equ{Name: name, Value: o.Defines[name]}, diags)
+	}
+
+	// pass one, add labels and constants to symbol table
 	pos := 0
 	for _, c := range program {
-		if cmd, ok := c.(command.L); ok {
-			symbols[cmd.Symbol] = pos
+		switch cmd := c.(type) {
+		case command.L:
+			if prev, ok := symbols.Lookup(cmd.Symbol); ok {
+				switch prev.Kind {
+				case Predefined:
+					diags.Add(diag.Errorf(cmd.Pos, diag.Redefined, "label %s redefines predefined symbol", cmd.Symbol))
+				case Constant:
+					diags.Add(diag.Errorf(cmd.Pos, diag.Redefined, "label %s already defined as a constant %s", cmd.Symbol, where(prev)))
+				default:
+					diags.Add(diag.Errorf(cmd.Pos, diag.DupLabel, "label %s already defined at %v", cmd.Symbol, prev.Pos))
+				}
+				continue
+			}
+			symbols.Define(Symbol{Name: cmd.Symbol, Kind: Label, Value: pos, Pos: &cmd.Pos})
+		case command.Equ:
+			defineConstant(symbols, cmd, diags)
+		case command.A, command.C:
+			pos++
+		}
+	}
+	return symbols
+}
+
+// defineConstant adds a constant, unless its name is taken
+func defineConstant(symbols *SymbolTable, cmd command.Equ, diags *diag.List) {
+	if prev, ok := symbols.Lookup(cmd.Name); ok {
+		switch prev.Kind {
+		case Predefined:
+			diags.Add(diag.Errorf(cmd.Pos, diag.Redefined, "constant %s redefines predefined symbol", cmd.Name))
+		case Label:
+			diags.Add(diag.Errorf(cmd.Pos, diag.Redefined, "constant %s already defined as a label at %v", cmd.Name, prev.Pos))
+		default:
+			diags.Add(diag.Errorf(cmd.Pos, diag.Redefined, "constant %s already defined %s", cmd.Name, where(prev)))
+		}
+		return
+	}
+	sym := Symbol{Name: cmd.Name, Kind: Constant, Value: cmd.Value}
+	if cmd.Pos != (token.Pos{}) {
+		// not defined by Options.Defines
+		sym.Pos = &cmd.Pos
+	}
+	symbols.Define(sym)
+}
+
+// where describes where a constant was defined, in the program or by Defines
+func where(sym Symbol) string {
+	if sym.Pos == nil {
+		return "on the command line"
+	}
+	return fmt.Sprintf("at %v", sym.Pos)
+}
+
+// linkage checks the .export and .import directives, returning the names
+// exported and imported. Exported labels must be defined. Imported labels
+// must be defined too when assembling a whole program, and must not be when
+// compiling a module, as another module defines them.
+func linkage(program command.Program, symbols *SymbolTable, module bool, diags *diag.List) (exported, imported map[string]bool) {
+	exported, imported = map[string]bool{}, map[string]bool{}
+	for _, c := range program {
+		d, ok := c.(command.Directive)
+		if !ok {
+			continue
+		}
+		if d.Name != "export" && d.Name != "import" {
+			diags.Add(diag.Errorf(d.Pos, diag.Syntax, "unknown directive .%s", d.Name))
 			continue
 		}
-		pos++
+		if len(d.Args) == 0 {
+			diags.Add(diag.Errorf(d.Pos, diag.Syntax, "missing label to %s", d.Name))
+		}
+		for _, name := range d.Args {
+			sym, ok := symbols.Lookup(name)
+			defined := ok && sym.Kind == Label
+			switch {
+			case d.Name == "export" && !defined:
+				diags.Add(diag.Errorf(d.Pos, diag.Link, "exported label %s is not defined", name))
+			case d.Name == "export":
+				exported[name] = true
+			case ok && sym.Kind != Label:
+				diags.Add(diag.Errorf(d.Pos, diag.Link, "cannot import %s %s", sym.Kind, name))
+			case module && defined:
+				diags.Add(diag.Errorf(d.Pos, diag.Link, "imported label %s is defined at %v", name, sym.Pos))
+			case !module && !defined:
+				diags.Add(diag.Errorf(d.Pos, diag.Link, "imported label %s is not defined", name))
+			default:
+				imported[name] = true
+			}
+		}
+	}
+	return exported, imported
+}
+
+// CheckLabelUse warns where a label is loaded into A and then used to access
+// RAM, which usually means a variable shares its name with a label.
+func CheckLabelUse(program command.Program, symbols *SymbolTable, diags *diag.List) {
+	var prev command.A
+	for _, c := range program {
+		switch cmd := c.(type) {
+		case command.A:
+			prev = cmd
+			continue
+		case command.C:
+			def, ok := symbols.Lookup(prev.Symbol)
+			if ok && def.Kind == Label && !prev.Static && (cmd.D.M || ReadsM(cmd.C)) {
+				diags.Add(diag.Warnf(prev.Pos, diag.LabelAsVar, "%s is a label defined at %v, but is used here as a variable", prev.Symbol, def.Pos))
+			}
+		case command.L:
+			continue
+		}
+		prev = command.A{}
+	}
+}
+
+// foldLabels maps the lower case names of labels to the labels.
+func foldLabels(symbols *SymbolTable) map[string]Symbol {
+	labels := map[string]Symbol{}
+	for _, sym := range symbols.Symbols() {
+		if sym.Kind == Label {
+			labels[strings.ToLower(sym.Name)] = sym
+		}
+	}
+	return labels
+}
+
+// checkVariable warns where a new variable's name collides with a label's,
+// differing only in case. Symbols are case sensitive, so the variable gets its
+// own RAM, but was probably meant to be the label.
+func checkVariable(cmd command.A, labels map[string]Symbol, diags *diag.List) {
+	if l, ok := labels[strings.ToLower(cmd.Symbol)]; ok {
+		diags.Add(diag.Warnf(cmd.Pos, diag.VarLikeLabel, "variable %s differs only in case from label %s defined at %v", cmd.Symbol, l.Name, l.Pos))
 	}
-	return symbols, nil
 }
 
 // assemble instructions from program and completed symbol table.
-func assemble(program command.Program, symbols table) ([]string, error) {
+func (o Options) assemble(program command.Program, symbols *SymbolTable, diags *diag.List) []string {
 	// pass two: if encountering an @SYMBOL
 	//		if an existing symbol, finalize the CmdA struct
 	//		if a new symbol, add to symbol table as a new user defined variable and finalize CmdA struct
 	var instructions []string
-	userVarPos := 0x010
+	userVarPos := VarStart
+	labels := foldLabels(symbols)
 	for _, c := range program {
 		switch cmd := c.(type) {
 		case command.C:
-			hack, err := cTos(cmd)
-			if err != nil {
-				return []string{}, fmt.Errorf("failed parsing C cmd: %v", err)
+			hack, ok := o.encodeC(cmd, diags)
+			if !ok {
+				continue
 			}
 			instructions = append(instructions, hack)
 		case command.A:
 			// TODO simplify
-			if !cmd.Static {
-				loc, ok := symbols[cmd.Symbol]
+			if cmd.Expr != nil {
+				v, ok := evaluate(cmd, symbols, diags)
 				if !ok {
-					loc = userVarPos
-					symbols[cmd.Symbol] = loc
+					continue
+				}
+				cmd.Address = v
+			} else if !cmd.Static {
+				sym, ok := symbols.Lookup(cmd.Symbol)
+				if !ok {
+					if userVarPos >= VarEnd {
+						diags.Add(diag.Errorf(cmd.Pos, diag.OutOfRAM, "no RAM left for variable %s, variables would overrun SCREEN at %d", cmd.Symbol, VarEnd))
+						continue
+					}
+					checkVariable(cmd, labels, diags)
+					sym = Symbol{Name: cmd.Symbol, Kind: Variable, Value: userVarPos, Pos: &cmd.Pos}
+					symbols.Define(sym)
 					userVarPos++
 				}
-				cmd.Address = loc
+				cmd.Address = sym.Value
 				// cmd.Final = true
+			} else if !checkAddress(cmd, diags) {
+				continue
 			}
 			hack := fmt.Sprintf("0%015b", cmd.Address)
 			instructions = append(instructions, hack)
 		}
 	}
 
-	return instructions, nil
+	return instructions
+}
+
+// evaluate an A instruction's expression, whose symbols must be labels,
+// constants or predefined, reporting problems to diags
+func evaluate(cmd command.A, symbols *SymbolTable, diags *diag.List) (int, bool) {
+	v, err := expr.Eval(cmd.Expr, func(name string) (int, bool) {
+		sym, ok := symbols.Lookup(name)
+		return sym.Value, ok && sym.Kind != Variable
+	})
+	if err != nil {
+		diags.Add(exprError(cmd, symbols, err))
+		return 0, false
+	}
+	if v < 0 || v > command.MaxAddress {
+		diags.Add(diag.Errorf(cmd.Pos, diag.BadAddress, "expression %s is %d, out of range 0..%d", cmd.Expr, v, command.MaxAddress))
+		return 0, false
+	}
+	return v, true
+}
+
+// exprError describes an error evaluating an A instruction's expression
+func exprError(cmd command.A, symbols *SymbolTable, err error) error {
+	u, ok := err.(*expr.UndefinedError)
+	if !ok {
+		return diag.Errorf(cmd.Pos, diag.Expr, "%v in expression %s", err, cmd.Expr)
+	}
+	pos := cmd.Pos
+	pos.Col += 1 + u.Sym.Offset
+	if sym, ok := symbols.Lookup(u.Sym.Name); ok && sym.Kind == Variable {
+		return diag.Errorf(pos, diag.Expr, "variable %s used in expression %s, only labels and constants can be", u.Sym.Name, cmd.Expr)
+	}
+	return diag.Errorf(pos, diag.Expr, "undefined symbol %s in expression %s", u.Sym.Name, cmd.Expr)
+}
+
+// encodeC encodes a C instruction, reporting problems to diags
+func (o Options) encodeC(cmd command.C, diags *diag.List) (string, bool) {
+	hack, err := cTos(cmd)
+	if err != nil {
+		diags.Add(err)
+		return "", false
+	}
+	if c, _ := Canonical(cmd.C); o.WarnNonCanonical && c != cmd.C && !IsRaw(cmd.C) {
+		d := diag.Warnf(cmd.Pos, diag.NonCanonical, "non-canonical computation %s", cmd.C)
+		diags.Add(d.Suggest(c))
+	}
+	return hack, true
+}
+
+// checkAddress reports a static A instruction's address out of range
+func checkAddress(cmd command.A, diags *diag.List) bool {
+	if cmd.Address < 0 || cmd.Address > command.MaxAddress {
+		diags.Add(diag.Errorf(cmd.Pos, diag.BadAddress, "address %d out of range, must be 0..%d", cmd.Address, command.MaxAddress))
+		return false
+	}
+	return true
 }
 
 func cTos(cmd command.C) (string, error) {
@@ -158,9 +493,9 @@ func cTos(cmd command.C) (string, error) {
 	p := 0b111
 
 	// comp flags - 7 bits
-	c, ok := comp[cmd.C]
-	if !ok {
-		return "", fmt.Errorf("unknown computation in '%+v': %s", cmd, cmd.C)
+	c, err := compBits(cmd)
+	if err != nil {
+		return "", err
 	}
 
 	// destination flags - 3 bits
@@ -177,8 +512,9 @@ func cTos(cmd command.C) (string, error) {
 
 	// jump flags - 3 bits
 	j, ok := jump[cmd.J]
-	if !ok {
-		j = 0
+	if !ok && cmd.J != "" {
+		d := diag.Errorf(cmd.Pos, diag.UnknownJump, "unknown jump: %s", cmd.J)
+		return "", d.Suggest(diag.Closest(cmd.J, mnemonics(jump)))
 	}
 
 	// combine - 3 + 7 + 3 + 3 = 16 bit instruction
@@ -186,3 +522,42 @@ func cTos(cmd command.C) (string, error) {
 	// FormatUint is slightly faster than fmt.Sprintf("%016b",i), per benchmarking
 	return strconv.FormatUint(uint64(instruction), 2), nil
 }
+
+// compBits returns the comp bits of a C command, or why they are invalid
+func compBits(cmd command.C) (int, error) {
+	if IsRaw(cmd.C) {
+		c, err := rawBits(cmd.C)
+		if err != nil {
+			return 0, diag.Errorf(cmd.Pos, diag.UnknownComp, "bad raw computation: %v", err)
+		}
+		return c, nil
+	}
+	canonical, ok := Canonical(cmd.C)
+	if !ok {
+		d := diag.Errorf(cmd.Pos, diag.UnknownComp, "unknown computation: %s", cmd.C)
+		return 0, d.Suggest(diag.Closest(cmd.C, mnemonics(comp)))
+	}
+	return comp[canonical], nil
+}
+
+// mnemonics returns the sorted keys of a lookup table
+func mnemonics(m map[string]int) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// inverse maps values back to mnemonics, the first in sort order wins where
+// several spellings share a value
+func inverse(m map[string]int) map[int]string {
+	result := make(map[int]string, len(m))
+	for _, k := range mnemonics(m) {
+		if _, ok := result[m[k]]; !ok {
+			result[m[k]] = k
+		}
+	}
+	return result
+}
@@ -1,16 +1,20 @@
 package assembler
 
 import (
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
 func TestA(t *testing.T) {
 	prog := command.Program{command.A{Address: 7, Static: true}}
-	o, err := Assemble(prog)
+	o, _, err := Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"0000000000000111"}, o)
 }
@@ -20,7 +24,7 @@ func TestC(t *testing.T) {
 		C: "M+1",
 		J: "",
 	}}
-	o, err := Assemble(prog)
+	o, _, err := Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"1111110111011000"}, o)
 }
@@ -30,7 +34,240 @@ func TestCWithJump(t *testing.T) {
 		C: "M+1",
 		J: "JMP",
 	}}
-	o, err := Assemble(prog)
+	o, _, err := Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, []string{"1111110111011111"}, o)
 }
+
+func TestUnknownJump(t *testing.T) {
+	prog := command.Program{command.C{C: "0", J: "JPM", Pos: token.Pos{Line: 4, Col: 1}}}
+	_, _, err := Assemble(prog, nil)
+	assert.EqualError(t, err, "4:1: error[E003]: unknown jump: JPM (did you mean JMP?)")
+}
+
+func TestAddressRange(t *testing.T) {
+	prog := command.Program{command.A{Address: command.MaxAddress, Static: true}}
+	o, _, err := Assemble(prog, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0111111111111111"}, o)
+
+	prog = command.Program{command.A{Address: command.MaxAddress + 1, Static: true, Pos: token.Pos{Line: 2, Col: 1}}}
+	_, _, err = Assemble(prog, nil)
+	assert.EqualError(t, err, "2:1: error[E006]: address 32768 out of range, must be 0..32767")
+}
+
+func TestOutOfRAM(t *testing.T) {
+	var prog command.Program
+	for i := 16; i < VarEnd; i++ {
+		prog = append(prog, command.A{Symbol: fmt.Sprintf("v%d", i)})
+	}
+	o, _, err := Assemble(prog, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "0011111111111111", o[len(o)-1])
+
+	prog = append(prog, command.A{Symbol: "v16"}, command.A{Symbol: "SCREEN"}, command.A{Symbol: "last", Pos: token.Pos{Line: 10, Col: 1}})
+	_, _, err = Assemble(prog, nil)
+	assert.EqualError(t, err, "10:1: error[E007]: no RAM left for variable last, variables would overrun SCREEN at 16384")
+}
+
+func TestVariableLikeLabel(t *testing.T) {
+	prog := command.Program{
+		command.L{Symbol: "LOOP", Pos: token.Pos{Line: 1, Col: 1}},
+		command.A{Symbol: "loop", Pos: token.Pos{Line: 2, Col: 1}},
+		command.C{C: "0", J: "JMP"},
+		command.A{Symbol: "loop", Pos: token.Pos{Line: 4, Col: 1}},
+		command.A{Symbol: "LOOP", Pos: token.Pos{Line: 5, Col: 1}},
+		command.A{Symbol: "i", Pos: token.Pos{Line: 6, Col: 1}},
+	}
+	var diags diag.List
+	words, symbols, err := Assemble(prog, &diags)
+	assert.NoError(t, err)
+	assert.Len(t, words, 5)
+	assert.Equal(t, "2:1: warning[W009]: variable loop differs only in case from label LOOP defined at 1:1", diags.Error())
+	sym, _ := symbols.Lookup("loop")
+	assert.Equal(t, VarStart, sym.Value)
+
+	diags = nil
+	_, err = Options{}.Compile("m", prog, &diags)
+	assert.NoError(t, err)
+	assert.Equal(t, "2:1: warning[W009]: variable loop differs only in case from label LOOP defined at 1:1", diags.Error())
+}
+
+func TestLabelRedefinition(t *testing.T) {
+	prog := command.Program{
+		command.L{Symbol: "LOOP", Pos: token.Pos{File: "a.asm", Line: 1, Col: 1}},
+		command.A{Symbol: "LOOP"},
+		command.L{Symbol: "LOOP", Pos: token.Pos{File: "a.asm", Line: 3, Col: 1}},
+		command.L{Symbol: "R3", Pos: token.Pos{File: "a.asm", Line: 4, Col: 1}},
+		command.L{Symbol: "SCREEN", Pos: token.Pos{File: "a.asm", Line: 5, Col: 1}},
+	}
+	_, _, err := Assemble(prog, nil)
+	assert.EqualError(t, err, `a.asm:3:1: error[E008]: label LOOP already defined at a.asm:1:1
+a.asm:4:1: error[E009]: label R3 redefines predefined symbol
+a.asm:5:1: error[E009]: label SCREEN redefines predefined symbol`)
+}
+
+func TestConstants(t *testing.T) {
+	prog := command.Program{
+		command.A{Symbol: "WIDTH"},
+		command.Equ{Directive: "equ", Name: "WIDTH", Value: 32, Pos: token.Pos{Line: 2, Col: 6}},
+		command.A{Symbol: "ROWS"},
+		command.A{Symbol: "i"},
+	}
+	o, symbols, err := Options{Defines: map[string]int{"ROWS": 256}}.Assemble(prog, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0000000000100000", "0000000100000000", "0000000000010000"}, o)
+	sym, _ := symbols.Lookup("WIDTH")
+	assert.Equal(t, Symbol{Name: "WIDTH", Kind: Constant, Value: 32, Pos: &token.Pos{Line: 2, Col: 6}}, sym)
+
+	prog = command.Program{
+		command.Equ{Directive: "equ", Name: "N", Value: 1, Pos: token.Pos{Line: 1, Col: 6}},
+		command.Equ{Directive: "define", Name: "N", Value: 2, Pos: token.Pos{Line: 2, Col: 9}},
+		command.Equ{Directive: "equ", Name: "KBD", Value: 3, Pos: token.Pos{Line: 3, Col: 6}},
+		command.L{Symbol: "N", Pos: token.Pos{Line: 4, Col: 1}},
+		command.L{Symbol: "LOOP", Pos: token.Pos{Line: 5, Col: 1}},
+		command.Equ{Directive: "equ", Name: "LOOP", Value: 4, Pos: token.Pos{Line: 6, Col: 6}},
+		command.Equ{Directive: "equ", Name: "D", Value: 5, Pos: token.Pos{Line: 7, Col: 6}},
+	}
+	_, _, err = Options{Defines: map[string]int{"D": 0}}.Assemble(prog, nil)
+	assert.EqualError(t, err, `2:9: error[E009]: constant N already defined at 1:6
+3:6: error[E009]: constant KBD redefines predefined symbol
+4:1: error[E009]: label N already defined as a constant at 1:6
+6:6: error[E009]: constant LOOP already defined as a label at 5:1
+7:6: error[E009]: constant D already defined on the command line`)
+}
+
+func TestExpressions(t *testing.T) {
+	parse := func(s string) expr.Expr {
+		e, err := expr.Parse(s)
+		assert.NoError(t, err)
+		return e
+	}
+	prog := command.Program{
+		command.Equ{Directive: "equ", Name: "ROW", Value: 2},
+		command.A{Expr: parse("ROW*32+1")},
+		command.A{Expr: parse("END-1")},
+		command.A{Expr: parse("(KBD-SCREEN)/2")},
+		command.L{Symbol: "END"},
+	}
+	o, _, err := Assemble(prog, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"0000000001000001", "0000000000000010", "0001000000000000"}, o)
+
+	prog = command.Program{
+		command.A{Symbol: "i", Pos: token.Pos{Line: 1, Col: 1}},
+		command.A{Expr: parse("SCREEN + x"), Pos: token.Pos{Line: 2, Col: 1}},
+		command.A{Expr: parse("i+1"), Pos: token.Pos{Line: 3, Col: 1}},
+		command.A{Expr: parse("SCREEN*2"), Pos: token.Pos{Line: 4, Col: 1}},
+		command.A{Expr: parse("1/0"), Pos: token.Pos{Line: 5, Col: 1}},
+	}
+	_, _, err = Assemble(prog, nil)
+	assert.EqualError(t, err, `2:11: error[E013]: undefined symbol x in expression SCREEN+x
+3:2: error[E013]: variable i used in expression i+1, only labels and constants can be
+4:1: error[E006]: expression SCREEN*2 is 32768, out of range 0..32767
+5:1: error[E013]: division by zero in expression 1/0`)
+}
+
+func TestLabelUsedAsVariable(t *testing.T) {
+	prog := command.Program{
+		command.A{Symbol: "i", Pos: token.Pos{Line: 1, Col: 1}},
+		command.C{D: command.Dest{M: true}, C: "1"},
+		command.A{Symbol: "i"},
+		command.C{C: "0", J: "JMP"},
+		command.L{Symbol: "i", Pos: token.Pos{Line: 5, Col: 1}},
+		command.A{Symbol: "i", Pos: token.Pos{Line: 6, Col: 1}},
+		command.C{D: command.Dest{D: true}, C: "M"},
+	}
+	var diags diag.List
+	o, _, err := Assemble(prog, &diags)
+	assert.NoError(t, err)
+	assert.Len(t, o, 6)
+	assert.Equal(t, `1:1: warning[W001]: i is a label defined at 5:1, but is used here as a variable
+6:1: warning[W001]: i is a label defined at 5:1, but is used here as a variable`, diags.Error())
+
+	diags = nil
+	_, err = Options{}.Compile("m", prog, &diags)
+	assert.NoError(t, err)
+	assert.Len(t, diags, 2)
+}
+
+func TestCommutativeComps(t *testing.T) {
+	testCases := map[string]string{
+		"A+D": "D+A",
+		"M+D": "D+M",
+		"A&D": "D&A",
+		"M&D": "D&M",
+		"A|D": "D|A",
+		"M|D": "D|M",
+		"1+D": "D+1",
+		"1+A": "A+1",
+		"1+M": "M+1",
+		"D+A": "D+A",
+		"D-A": "D-A",
+	}
+	for spelling, canonical := range testCases {
+		c, ok := Canonical(spelling)
+		assert.True(t, ok, spelling)
+		assert.Equal(t, canonical, c, spelling)
+
+		a, _, err := Assemble(command.Program{command.C{C: spelling}}, nil)
+		assert.NoError(t, err, spelling)
+		b, _, _ := Assemble(command.Program{command.C{C: canonical}}, nil)
+		assert.Equal(t, b, a, spelling)
+	}
+	for _, spelling := range []string{"A-D+", "1-D", "D+D", "A+M", "_a"} {
+		_, ok := Canonical(spelling)
+		assert.False(t, ok, spelling)
+	}
+}
+
+func TestWarnNonCanonical(t *testing.T) {
+	prog := command.Program{
+		command.C{D: command.Dest{D: true}, C: "A+D", Pos: token.Pos{Line: 1, Col: 1}},
+		command.C{D: command.Dest{D: true}, C: "D+A", Pos: token.Pos{Line: 2, Col: 1}},
+	}
+	var diags diag.List
+	o, _, err := Options{WarnNonCanonical: true}.Assemble(prog, &diags)
+	assert.NoError(t, err)
+	assert.Len(t, o, 2)
+	assert.EqualError(t, diags, "1:1: warning[W008]: non-canonical computation A+D (did you mean D+A?)")
+
+	diags = nil
+	_, _, err = Assemble(prog, &diags)
+	assert.NoError(t, err)
+	assert.Empty(t, diags)
+}
+
+func TestRawComps(t *testing.T) {
+	testCases := map[string]string{
+		"%0001100":                              "1110001100000000",
+		"alu(zy=1,ny=1)":                        "1110001100000000",
+		"alu(no=1, f=1, ny=1, zy=0, a=1)":       "1111000111000000",
+		"%1111111":                              "1111111111000000",
+		"alu()":                                 "1110000000000000",
+		"alu(a=0,zx=0,nx=0,zy=0,ny=0,f=0,no=0)": "1110000000000000",
+	}
+	for raw, expected := range testCases {
+		o, _, err := Assemble(command.Program{command.C{C: raw}}, nil)
+		assert.NoError(t, err, raw)
+		assert.Equal(t, []string{expected}, o, raw)
+	}
+
+	for _, raw := range []string{"%000110", "%00011002", "alu(a=2)", "alu(q=1)", "alu(a=1,a=0)", "alu(a)", "alu(a=1"} {
+		var diags diag.List
+		_, _, err := Assemble(command.Program{command.C{C: raw}}, &diags)
+		assert.Error(t, err, raw)
+		assert.Equal(t, diag.UnknownComp, diags[0].Code, raw)
+	}
+}
+
+func TestReadsMUsesA(t *testing.T) {
+	assert.True(t, ReadsM("M+1"))
+	assert.True(t, ReadsM("%1110000"))
+	assert.False(t, ReadsM("alu(a=1,zy=1)"))
+	assert.False(t, ReadsM("D+A"))
+	assert.True(t, UsesA("A+D"))
+	assert.True(t, UsesA("alu()"))
+	assert.False(t, UsesA("%0001100"))
+	assert.False(t, UsesA("D|M"))
+}
@@ -0,0 +1,106 @@
+package assembler
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
+)
+
+// Compile a program to an object named module, for linking with others. A
+// instructions loading a label, import or variable, or an expression of
+// labels and imports, are left as relocations, all other instructions are
+// encoded as by Assemble.
+func (o Options) Compile(module string, program command.Program, diags *diag.List) (*object.Object, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	symbols := o.build(program, diags)
+	CheckLabelUse(program, symbols, diags)
+	exported, imported := linkage(program, symbols, true, diags)
+
+	obj := &object.Object{Format: object.Format, Module: module, Words: []string{}, Labels: []object.Label{}, Relocations: []object.Relocation{}, Imports: []string{}, Variables: []string{}}
+	for _, sym := range symbols.Symbols() {
+		if sym.Kind == Label {
+			obj.Labels = append(obj.Labels, object.Label{Name: sym.Name, Value: sym.Value, Export: exported[sym.Name], Pos: *sym.Pos})
+		}
+	}
+	for name := range imported {
+		obj.Imports = append(obj.Imports, name)
+	}
+	sort.Strings(obj.Imports)
+	variables := map[string]bool{}
+	labels := foldLabels(symbols)
+	for _, c := range program {
+		switch cmd := c.(type) {
+		case command.C:
+			hack, ok := o.encodeC(cmd, diags)
+			if !ok {
+				continue
+			}
+			obj.Words = append(obj.Words, hack)
+		case command.A:
+			if cmd.Expr != nil {
+				e, ok := fold(cmd, symbols, imported, diags)
+				if !ok {
+					continue
+				}
+				if n, ok := e.(expr.Num); ok {
+					obj.Words = append(obj.Words, fmt.Sprintf("0%015b", n.Value))
+					continue
+				}
+				obj.Relocations = append(obj.Relocations, object.Relocation{Addr: len(obj.Words), Expr: e.String(), Pos: cmd.Pos})
+				obj.Words = append(obj.Words, "0000000000000000")
+				continue
+			}
+			if cmd.Static {
+				if !checkAddress(cmd, diags) {
+					continue
+				}
+				obj.Words = append(obj.Words, fmt.Sprintf("0%015b", cmd.Address))
+				continue
+			}
+			sym, ok := symbols.Lookup(cmd.Symbol)
+			if ok && (sym.Kind == Predefined || sym.Kind == Constant) {
+				obj.Words = append(obj.Words, fmt.Sprintf("0%015b", sym.Value))
+				continue
+			}
+			if !ok && !imported[cmd.Symbol] && !variables[cmd.Symbol] {
+				checkVariable(cmd, labels, diags)
+				variables[cmd.Symbol] = true
+				obj.Variables = append(obj.Variables, cmd.Symbol)
+			}
+			obj.Relocations = append(obj.Relocations, object.Relocation{Addr: len(obj.Words), Symbol: cmd.Symbol, Pos: cmd.Pos})
+			obj.Words = append(obj.Words, "0000000000000000")
+		}
+	}
+	return obj, diags.Err()
+}
+
+// fold evaluates the parts of an A instruction's expression known before
+// linking, leaving its labels and imports. Any other symbols are reported as
+// undefined.
+func fold(cmd command.A, symbols *SymbolTable, imported map[string]bool, diags *diag.List) (expr.Expr, bool) {
+	e, err := expr.Fold(cmd.Expr, func(name string) (int, bool) {
+		sym, ok := symbols.Lookup(name)
+		return sym.Value, ok && (sym.Kind == Predefined || sym.Kind == Constant)
+	})
+	if err != nil {
+		diags.Add(exprError(cmd, symbols, err))
+		return nil, false
+	}
+	for _, s := range expr.Symbols(e) {
+		if sym, ok := symbols.Lookup(s.Name); !(ok && sym.Kind == Label) && !imported[s.Name] {
+			diags.Add(exprError(cmd, symbols, &expr.UndefinedError{Sym: s}))
+			return nil, false
+		}
+	}
+	if n, ok := e.(expr.Num); ok && (n.Value < 0 || n.Value > command.MaxAddress) {
+		diags.Add(diag.Errorf(cmd.Pos, diag.BadAddress, "expression %s is %d, out of range 0..%d", cmd.Expr, n.Value, command.MaxAddress))
+		return nil, false
+	}
+	return e, true
+}
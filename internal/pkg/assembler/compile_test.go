@@ -0,0 +1,80 @@
+package assembler
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+func TestCompile(t *testing.T) {
+	pos := func(line int) token.Pos { return token.Pos{File: "m.asm", Line: line, Col: 1} }
+	prog := command.Program{
+		command.Directive{Name: "export", Args: []string{"LOOP"}, Pos: pos(1)},
+		command.Directive{Name: "import", Args: []string{"LIB"}, Pos: pos(2)},
+		command.L{Symbol: "LOOP", Pos: pos(3)},
+		command.A{Symbol: "i", Pos: pos(4)},
+		command.A{Symbol: "SCREEN", Pos: pos(5)},
+		command.A{Address: 7, Static: true, Pos: pos(6)},
+		command.A{Symbol: "LIB", Pos: pos(7)},
+		command.A{Symbol: "LOOP", Pos: pos(8)},
+		command.A{Symbol: "i", Pos: pos(9)},
+		command.C{C: "0", J: "JMP", Pos: pos(10)},
+	}
+	o, err := Options{}.Compile("m", prog, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &object.Object{
+		Format: object.Format,
+		Module: "m",
+		Words: []string{
+			"0000000000000000",
+			"0100000000000000",
+			"0000000000000111",
+			"0000000000000000",
+			"0000000000000000",
+			"0000000000000000",
+			"1110101010000111",
+		},
+		Labels: []object.Label{{Name: "LOOP", Value: 0, Export: true, Pos: pos(3)}},
+		Relocations: []object.Relocation{
+			{Addr: 0, Symbol: "i", Pos: pos(4)},
+			{Addr: 3, Symbol: "LIB", Pos: pos(7)},
+			{Addr: 4, Symbol: "LOOP", Pos: pos(8)},
+			{Addr: 5, Symbol: "i", Pos: pos(9)},
+		},
+		Imports:   []string{"LIB"},
+		Variables: []string{"i"},
+	}, o)
+}
+
+func TestLinkage(t *testing.T) {
+	prog := command.Program{
+		command.Directive{Name: "export", Args: []string{"X", "i"}, Pos: token.Pos{Line: 1, Col: 1}},
+		command.Directive{Name: "import", Args: []string{"KBD", "END"}, Pos: token.Pos{Line: 2, Col: 1}},
+		command.Directive{Name: "extern", Pos: token.Pos{Line: 3, Col: 1}},
+		command.Directive{Name: "export", Pos: token.Pos{Line: 4, Col: 1}},
+		command.L{Symbol: "END", Pos: token.Pos{Line: 5, Col: 1}},
+		command.A{Symbol: "i", Pos: token.Pos{Line: 6, Col: 1}},
+	}
+	var diags diag.List
+	_, err := Options{}.Compile("m", prog, &diags)
+	assert.Error(t, err)
+	assert.Equal(t, `1:1: error[E012]: exported label X is not defined
+1:1: error[E012]: exported label i is not defined
+2:1: error[E012]: cannot import predefined KBD
+2:1: error[E012]: imported label END is defined at 5:1
+3:1: error[E001]: unknown directive .extern
+4:1: error[E001]: missing label to export`, diags.Error())
+
+	// the whole program is assembled, so imports must be defined
+	prog = command.Program{
+		command.Directive{Name: "import", Args: []string{"LIB"}, Pos: token.Pos{Line: 1, Col: 1}},
+		command.A{Symbol: "LIB", Pos: token.Pos{Line: 2, Col: 1}},
+	}
+	_, _, err = Assemble(prog, nil)
+	assert.EqualError(t, err, "1:1: error[E012]: imported label LIB is not defined")
+}
@@ -0,0 +1,182 @@
+package assembler
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Kind of symbol
+type Kind int
+
+const (
+	Predefined Kind = iota
+	Label
+	Variable
+	Constant
+)
+
+var kindNames = [...]string{
+	Predefined: "predefined",
+	Label:      "label",
+	Variable:   "variable",
+	Constant:   "constant",
+}
+
+func (k Kind) String() string {
+	if k < 0 || int(k) >= len(kindNames) {
+		return fmt.Sprintf("Kind(%d)", int(k))
+	}
+	return kindNames[k]
+}
+
+// MarshalText encodes the kind by name, for JSON
+func (k Kind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+// UnmarshalText decodes a kind by name
+func (k *Kind) UnmarshalText(b []byte) error {
+	for i, n := range kindNames {
+		if n == string(b) {
+			*k = Kind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symbol kind: %s", b)
+}
+
+// Symbol is a named value and where it was defined. Predefined symbols and
+// constants defined by Options.Defines have no position, nil, variables are
+// positioned at their first use.
+type Symbol struct {
+	Name  string     `json:"name"`
+	Kind  Kind       `json:"kind"`
+	Value int        `json:"value"`
+	Pos   *token.Pos `json:"pos,omitempty"`
+}
+
+// SymbolTable maps names to symbols, remembering definition order.
+type SymbolTable struct {
+	symbols map[string]Symbol
+	order   []string
+}
+
+// NewSymbolTable returns a table holding the predefined Hack symbols.
+func NewSymbolTable() *SymbolTable {
+	t := &SymbolTable{symbols: make(map[string]Symbol, 64)}
+	for _, s := range []struct {
+		name  string
+		value int
+	}{
+		{"SP", 0x0000},
+		{"LCL", 0x0001},
+		{"ARG", 0x0002},
+		{"THIS", 0x0003},
+		{"THAT", 0x0004},
+		{"R0", 0x0000},
+		{"R1", 0x0001},
+		{"R2", 0x0002},
+		{"R3", 0x0003},
+		{"R4", 0x0004},
+		{"R5", 0x0005},
+		{"R6", 0x0006},
+		{"R7", 0x0007},
+		{"R8", 0x0008},
+		{"R9", 0x0009},
+		{"R10", 0x00a},
+		{"R11", 0x00b},
+		{"R12", 0x00c},
+		{"R13", 0x00d},
+		{"R14", 0x00e},
+		{"R15", 0x00f},
+		{"SCREEN", 0x4000},
+		{"KBD", 0x6000},
+	} {
+		t.Define(Symbol{Name: s.name, Kind: Predefined, Value: s.value})
+	}
+	return t
+}
+
+// Define adds or replaces a symbol.
+func (t *SymbolTable) Define(s Symbol) {
+	if t.symbols == nil {
+		t.symbols = map[string]Symbol{}
+	}
+	if _, ok := t.symbols[s.Name]; !ok {
+		t.order = append(t.order, s.Name)
+	}
+	t.symbols[s.Name] = s
+}
+
+// Lookup a symbol by name.
+func (t *SymbolTable) Lookup(name string) (Symbol, bool) {
+	s, ok := t.symbols[name]
+	return s, ok
+}
+
+// Symbols returns all symbols in definition order.
+func (t *SymbolTable) Symbols() []Symbol {
+	result := make([]Symbol, len(t.order))
+	for i, name := range t.order {
+		result[i] = t.symbols[name]
+	}
+	return result
+}
+
+// WriteText writes one symbol per line as: name value kind [file:line:col]
+func (t *SymbolTable) WriteText(w io.Writer) error {
+	for _, s := range t.Symbols() {
+		var err error
+		if s.Pos != nil {
+			_, err = fmt.Fprintf(w, "%s %d %v %v\n", s.Name, s.Value, s.Kind, s.Pos)
+		} else {
+			_, err = fmt.Fprintf(w, "%s %d %v\n", s.Name, s.Value, s.Kind)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteJSON writes the symbols as a JSON array in definition order.
+func (t *SymbolTable) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(t.Symbols())
+}
+
+// ReadText reads a symbol table in the format written by WriteText.
+// Positions are not read back.
+func ReadText(r io.Reader) (*SymbolTable, error) {
+	t := &SymbolTable{}
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("line %d: expected name value kind, got: %s", n, scanner.Text())
+		}
+		v, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: bad value: %v", n, err)
+		}
+		var k Kind
+		if err := k.UnmarshalText([]byte(fields[2])); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		t.Define(Symbol{Name: fields[0], Kind: k, Value: v})
+	}
+	return t, scanner.Err()
+}
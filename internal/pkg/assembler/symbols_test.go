@@ -0,0 +1,74 @@
+package assembler
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+func TestSymbolTable(t *testing.T) {
+	prog := command.Program{
+		command.A{Symbol: "i", Pos: token.Pos{File: "a.asm", Line: 1, Col: 1}},
+		command.L{Symbol: "LOOP", Pos: token.Pos{File: "a.asm", Line: 2, Col: 1}},
+		command.A{Symbol: "LOOP", Pos: token.Pos{File: "a.asm", Line: 3, Col: 1}},
+		command.A{Symbol: "j", Pos: token.Pos{File: "a.asm", Line: 4, Col: 1}},
+		command.A{Symbol: "i", Pos: token.Pos{File: "a.asm", Line: 5, Col: 1}},
+	}
+	_, symbols, err := Assemble(prog, nil)
+	assert.NoError(t, err)
+
+	s, ok := symbols.Lookup("LOOP")
+	assert.True(t, ok)
+	assert.Equal(t, Symbol{Name: "LOOP", Kind: Label, Value: 1, Pos: &token.Pos{File: "a.asm", Line: 2, Col: 1}}, s)
+	s, _ = symbols.Lookup("i")
+	assert.Equal(t, Symbol{Name: "i", Kind: Variable, Value: 16, Pos: &token.Pos{File: "a.asm", Line: 1, Col: 1}}, s)
+	s, _ = symbols.Lookup("KBD")
+	assert.Equal(t, Symbol{Name: "KBD", Kind: Predefined, Value: 0x6000}, s)
+
+	all := symbols.Symbols()
+	assert.Len(t, all, 26)
+	assert.Equal(t, "SP", all[0].Name)
+	assert.Equal(t, []string{"LOOP", "i", "j"}, []string{all[23].Name, all[24].Name, all[25].Name})
+
+	var text bytes.Buffer
+	assert.NoError(t, symbols.WriteText(&text))
+	assert.Contains(t, text.String(), "SP 0 predefined\n")
+	assert.Contains(t, text.String(), "LOOP 1 label a.asm:2:1\ni 16 variable a.asm:1:1\nj 17 variable a.asm:4:1\n")
+
+	var js bytes.Buffer
+	assert.NoError(t, symbols.WriteJSON(&js))
+	var decoded []Symbol
+	assert.NoError(t, json.Unmarshal(js.Bytes(), &decoded))
+	assert.Equal(t, all, decoded)
+	assert.Contains(t, js.String(), `"name": "SP",
+    "kind": "predefined",
+    "value": 0
+  },`)
+
+	// constants defined by Options.Defines have no position either
+	_, symbols, err = Options{Defines: map[string]int{"W": 32}}.Assemble(prog, nil)
+	assert.NoError(t, err)
+	s, _ = symbols.Lookup("W")
+	assert.Nil(t, s.Pos)
+}
+
+func TestReadText(t *testing.T) {
+	symbols, err := ReadText(strings.NewReader("R0 0 predefined\nLOOP 4 label a.asm:2:1\n\ni 16 variable\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []Symbol{
+		{Name: "R0", Kind: Predefined, Value: 0},
+		{Name: "LOOP", Kind: Label, Value: 4},
+		{Name: "i", Kind: Variable, Value: 16},
+	}, symbols.Symbols())
+
+	_, err = ReadText(strings.NewReader("i 16 thing\n"))
+	assert.EqualError(t, err, "line 1: unknown symbol kind: thing")
+	_, err = ReadText(strings.NewReader("i\n"))
+	assert.Error(t, err)
+}
@@ -1,5 +1,13 @@
 package command
 
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
 // Program is a sequence of commands.
 type Program []Any
 
@@ -9,20 +17,49 @@ type Any interface{}
 // L type command
 type L struct {
 	Symbol string
+	Pos    token.Pos
+}
+
+// Directive is a statement such as .export NAME, taking comma separated
+// arguments.
+type Directive struct {
+	Name string
+	Args []string
+	Pos  token.Pos
+}
+
+// Equ defines a named constant, with .equ NAME VALUE or its synonym
+// .define. Pos is the position of Name, and Text the spelling of a Value
+// which isn't decimal.
+type Equ struct {
+	Directive string
+	Name      string
+	Value     int
+	Text      string
+	Pos       token.Pos
 }
 
-// A type command
+// MaxAddress is the largest value an A command can load, 15 bits.
+const MaxAddress = 1<<15 - 1
+
+// A type command, loading a Static Address, a Symbol or, when Expr is not
+// nil, the value of an expression. Text is the spelling of a Static Address
+// which isn't decimal, such as 0x4000.
 type A struct {
 	Address int
 	Symbol  string
 	Static  bool
+	Text    string
+	Expr    expr.Expr
+	Pos     token.Pos
 }
 
 // C type command
 type C struct {
-	D Dest
-	C string
-	J string
+	D   Dest
+	C   string
+	J   string
+	Pos token.Pos
 }
 
 // Dest part of C type command
@@ -31,3 +68,61 @@ type Dest struct {
 	D bool
 	M bool
 }
+
+func (c L) String() string {
+	return "(" + c.Symbol + ")"
+}
+
+func (c Directive) String() string {
+	if len(c.Args) == 0 {
+		return "." + c.Name
+	}
+	return "." + c.Name + " " + strings.Join(c.Args, ", ")
+}
+
+func (c Equ) String() string {
+	if c.Text != "" {
+		return "." + c.Directive + " " + c.Name + " " + c.Text
+	}
+	return "." + c.Directive + " " + c.Name + " " + strconv.Itoa(c.Value)
+}
+
+func (c A) String() string {
+	if c.Static && c.Text != "" {
+		return "@" + c.Text
+	}
+	if c.Static {
+		return "@" + strconv.Itoa(c.Address)
+	}
+	if c.Expr != nil {
+		return "@" + c.Expr.String()
+	}
+	return "@" + c.Symbol
+}
+
+// String formats the command as dest=comp;jump, omitting empty parts.
+func (c C) String() string {
+	s := c.C
+	if d := c.D.String(); d != "" {
+		s = d + "=" + s
+	}
+	if c.J != "" {
+		s += ";" + c.J
+	}
+	return s
+}
+
+// String formats destinations in the canonical order, e.g. AMD
+func (d Dest) String() string {
+	s := ""
+	if d.A {
+		s += "A"
+	}
+	if d.M {
+		s += "M"
+	}
+	if d.D {
+		s += "D"
+	}
+	return s
+}
@@ -0,0 +1,141 @@
+package cpu
+
+import "fmt"
+
+// Sizes and memory map of the Hack computer
+const (
+	ROMSize = 1 << 15
+	RAMSize = 1 << 15
+	Screen  = 0x4000
+	KBD     = 0x6000
+)
+
+// CPU simulates the Hack computer: registers, 32K ROM and 32K RAM, with the
+// screen and keyboard memory mapped at Screen and KBD.
+type CPU struct {
+	A, D, PC uint16
+	ROM      [ROMSize]uint16
+	RAM      [RAMSize]uint16
+	// Cycles counts executed instructions
+	Cycles int
+}
+
+// New returns a CPU with program loaded into ROM.
+func New(program []uint16) (*CPU, error) {
+	c := &CPU{}
+	if err := c.Load(program); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load program into ROM, clearing the rest of ROM.
+func (c *CPU) Load(program []uint16) error {
+	if len(program) > ROMSize {
+		return fmt.Errorf("program of %d words does not fit in %d words of ROM", len(program), ROMSize)
+	}
+	c.ROM = [ROMSize]uint16{}
+	copy(c.ROM[:], program)
+	return nil
+}
+
+// Reset the registers and cycle count, leaving memory as-is.
+func (c *CPU) Reset() {
+	c.A, c.D, c.PC, c.Cycles = 0, 0, 0, 0
+}
+
+// Step executes the instruction at PC.
+func (c *CPU) Step() {
+	instr := c.ROM[c.PC%ROMSize]
+	c.Cycles++
+
+	if instr&0x8000 == 0 {
+		c.A = instr
+		c.PC++
+		return
+	}
+
+	y := c.A
+	if instr&0x1000 != 0 {
+		y = c.RAM[c.A%RAMSize]
+	}
+	out := ALU(c.D, y, instr>>6)
+
+	// memory and jump address use A from before this instruction
+	addr := c.A
+	if instr&0x08 != 0 {
+		c.RAM[addr%RAMSize] = out
+	}
+	if instr&0x20 != 0 {
+		c.A = out
+	}
+	if instr&0x10 != 0 {
+		c.D = out
+	}
+
+	if jumps(out, instr&0x7) {
+		c.PC = addr
+	} else {
+		c.PC++
+	}
+}
+
+// Run steps until the program halts or max cycles have run, returning true
+// if it halted.
+func (c *CPU) Run(max int) bool {
+	for n := 0; n < max; n++ {
+		if c.Halted() {
+			return true
+		}
+		c.Step()
+	}
+	return c.Halted()
+}
+
+// Halted returns true if the CPU is in a halt loop: an unconditional jump
+// which doesn't write anything, targeting itself or an immediately preceding
+// @X loading its own address X, the idiom (X) @X 0;JMP.
+func (c *CPU) Halted() bool {
+	instr := c.ROM[c.PC%ROMSize]
+	if instr&0xe000 != 0xe000 || instr&0x7 != 0x7 || instr&0x38 != 0 {
+		return false
+	}
+	if c.A == c.PC {
+		return true
+	}
+	return c.PC > 0 && c.A == c.PC-1 && c.ROM[c.PC-1] == c.PC-1
+}
+
+// ALU computes the Hack ALU output for inputs x and y and the 7 comp bits
+// a zx nx zy ny f no, in the low bits of comp. The a bit is ignored, it
+// selects y before the ALU.
+func ALU(x, y, comp uint16) uint16 {
+	if comp&0x20 != 0 { // zx
+		x = 0
+	}
+	if comp&0x10 != 0 { // nx
+		x = ^x
+	}
+	if comp&0x08 != 0 { // zy
+		y = 0
+	}
+	if comp&0x04 != 0 { // ny
+		y = ^y
+	}
+	var out uint16
+	if comp&0x02 != 0 { // f
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if comp&0x01 != 0 { // no
+		out = ^out
+	}
+	return out
+}
+
+// jumps returns true if the 3 jump bits j1 j2 j3 select out
+func jumps(out uint16, j uint16) bool {
+	v := int16(out)
+	return (j&0x4 != 0 && v < 0) || (j&0x2 != 0 && v == 0) || (j&0x1 != 0 && v > 0)
+}
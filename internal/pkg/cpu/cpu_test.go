@@ -0,0 +1,83 @@
+package cpu
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestALU(t *testing.T) {
+	x, y := uint16(5), uint16(3)
+	neg := func(v int) uint16 { return uint16(int16(v)) }
+	testCases := map[uint16]uint16{
+		0b101010: 0,
+		0b111111: 1,
+		0b111010: neg(-1),
+		0b001100: x,
+		0b110000: y,
+		0b001101: ^x,
+		0b110001: ^y,
+		0b001111: neg(-5),
+		0b110011: neg(-3),
+		0b011111: x + 1,
+		0b110111: y + 1,
+		0b001110: x - 1,
+		0b110010: y - 1,
+		0b000010: x + y,
+		0b010011: x - y,
+		0b000111: y - x,
+		0b000000: x & y,
+		0b010101: x | y,
+	}
+	for comp, expected := range testCases {
+		assert.Equal(t, expected, ALU(x, y, comp), "%06b", comp)
+	}
+}
+
+func TestStep(t *testing.T) {
+	c, err := New([]uint16{
+		7,                  // @7
+		0b1110110000010000, // D=A
+		100,                // @100
+		0b1110001100001000, // M=D
+		0b1111110111100000, // A=M+1
+		0b1110001100000001, // D;JGT
+	})
+	assert.NoError(t, err)
+	for i := 0; i < 5; i++ {
+		c.Step()
+	}
+	assert.Equal(t, uint16(7), c.RAM[100])
+	assert.Equal(t, uint16(8), c.A)
+	assert.Equal(t, uint16(5), c.PC)
+	c.Step()
+	assert.Equal(t, uint16(8), c.PC)
+	assert.Equal(t, 6, c.Cycles)
+}
+
+func TestRunUntilHalt(t *testing.T) {
+	c, err := New([]uint16{
+		0,                  // @0
+		0b1110111111001000, // M=1
+		2,                  // (END) @END
+		0b1110101010000111, // 0;JMP
+	})
+	assert.NoError(t, err)
+	assert.False(t, c.Halted())
+	assert.True(t, c.Run(100))
+	assert.Equal(t, 3, c.Cycles)
+	assert.Equal(t, uint16(1), c.RAM[0])
+
+	// jump to self
+	c, _ = New([]uint16{1, 0b1110101010000111})
+	assert.True(t, c.Run(100))
+	assert.Equal(t, 1, c.Cycles)
+
+	// loop that never halts
+	c, _ = New([]uint16{0b1110011111010000, 0, 0b1110101010000111})
+	assert.False(t, c.Run(50))
+	assert.Equal(t, 50, c.Cycles)
+
+	_, err = New(make([]uint16, ROMSize+1))
+	assert.Error(t, err)
+}
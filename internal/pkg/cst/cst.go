@@ -0,0 +1,135 @@
+// Package cst builds a lossless concrete syntax tree of Hack assembly, which
+// keeps every comment, blank line and whitespace byte so source can be
+// printed back exactly.
+package cst
+
+import (
+	"io"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// File is the tree of a source file.
+type File struct {
+	Statements []*Statement
+	// EOF leads with the trivia after the last statement
+	EOF token.Token
+}
+
+// Statement is one command with its tokens, including punctuation and trivia.
+type Statement struct {
+	Tokens []token.Token
+	// Command is nil if the statement failed to parse, or is Verbatim
+	Command command.Any
+}
+
+// Parse r into a tree, recording name as the file of positions. Problems are
+// reported to diags, the returned error is non-nil if diags holds any errors;
+// the tree is complete regardless, with lines that failed to tokenize kept as
+// skipped trivia.
+func Parse(name string, r io.Reader, diags *diag.List) (*File, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	l := lex.New()
+	l.Trivia = true
+	tokens, err := l.Tokenize(name, r, diags)
+	if _, ok := err.(diag.List); err != nil && !ok {
+		return nil, err
+	}
+
+	f := &File{}
+	start := 0
+	for i, t := range tokens {
+		switch t.Type {
+		case token.EOF:
+			f.EOF = t
+		case token.END:
+			s := &Statement{Tokens: tokens[start : i+1 : i+1]}
+			if !s.Verbatim() {
+				program, _ := parser.Parse(s.syntax(), diags)
+				if len(program) == 1 {
+					s.Command = program[0]
+				}
+			}
+			f.Statements = append(f.Statements, s)
+			start = i + 1
+		}
+	}
+	return f, diags.Err()
+}
+
+// syntax returns the tokens the parser expects, without punctuation
+func (s *Statement) syntax() []token.Token {
+	result := make([]token.Token, 0, len(s.Tokens))
+	for _, t := range s.Tokens {
+		if t.Type != token.RPAREN && t.Type != token.SEMICOLON {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// Program returns the commands of statements which parsed.
+func (f *File) Program() command.Program {
+	program := command.Program{}
+	for _, s := range f.Statements {
+		if s.Command != nil {
+			program = append(program, s.Command)
+		}
+	}
+	return program
+}
+
+// String prints the source back exactly.
+func (f *File) String() string {
+	var b strings.Builder
+	for _, s := range f.Statements {
+		for _, t := range s.Tokens {
+			b.WriteString(t.Full())
+		}
+	}
+	b.WriteString(f.EOF.Full())
+	return b.String()
+}
+
+// Text returns the statement's source without trivia.
+func (s *Statement) Text() string {
+	var b strings.Builder
+	for _, t := range s.Tokens {
+		b.WriteString(t.Value)
+	}
+	return b.String()
+}
+
+// Verbatim returns true for lines of macro definitions and macro calls,
+// which are kept as written rather than parsed.
+func (s *Statement) Verbatim() bool {
+	return s.Tokens[0].Type == token.MACRO
+}
+
+// Leading returns the trivia before the statement, which includes comment
+// and blank lines above it and its indentation.
+func (s *Statement) Leading() []token.Trivia {
+	return s.Tokens[0].Leading
+}
+
+// Trailing returns the trivia after the statement to the end of its line.
+func (s *Statement) Trailing() []token.Trivia {
+	return s.Tokens[len(s.Tokens)-1].Trailing
+}
+
+// Comment returns the comment after the statement, if any.
+func (s *Statement) Comment() string {
+	for _, t := range s.Trailing() {
+		if t.Kind == token.Comment {
+			return t.Text
+		}
+	}
+	return ""
+}
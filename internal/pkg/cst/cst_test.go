@@ -0,0 +1,112 @@
+package cst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+	"github.com/stretchr/testify/assert"
+)
+
+const src = `// Computes R2 = max(R0, R1)
+
+   @R0
+	D=M              // D = first number
+   @R1
+   D=D-M
+   @OUTPUT_FIRST
+   D;JGT            // if D>0 goto output_first
+(OUTPUT_FIRST)  // label
+   @R0
+   MD=M+1
+(END)
+   @END
+   0;JMP
+  // trailing comment
+
+`
+
+func TestRoundTrip(t *testing.T) {
+	testCases := []string{
+		src,
+		strings.Replace(src, "\n", "\r\n", -1),
+		"@1\nD=A",
+		"",
+		"\n\n",
+		"   ",
+		"// only a comment",
+		"@1\nD=M;JPM  // bad jump\n  M=0\n",
+		"(LOOP)\n@LOOP\r\n0;JMP\t\n",
+		".macro INC x // inc\n  @x\n  M=M+1\n.endm\n\tINC  i\r\n",
+	}
+	for _, s := range testCases {
+		f, _ := Parse("x.asm", strings.NewReader(s), nil)
+		assert.Equal(t, s, f.String())
+	}
+}
+
+func TestProgram(t *testing.T) {
+	f, err := Parse("max.asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+
+	tokens, err := lex.TokenizeFile("max.asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, program, f.Program())
+
+	assert.Len(t, f.Statements, 12)
+	s := f.Statements[1]
+	assert.Equal(t, "D=M", s.Text())
+	assert.Equal(t, "// D = first number", s.Comment())
+	assert.Equal(t, []token.Trivia{{Kind: token.Whitespace, Text: "\t"}}, s.Leading())
+
+	s = f.Statements[0]
+	assert.Equal(t, []token.Trivia{
+		{Kind: token.Comment, Text: "// Computes R2 = max(R0, R1)"},
+		{Kind: token.Newline, Text: "\n"},
+		{Kind: token.Newline, Text: "\n"},
+		{Kind: token.Whitespace, Text: "   "},
+	}, s.Leading())
+
+	assert.Equal(t, "D;JGT", f.Statements[5].Text())
+	assert.Equal(t, "(OUTPUT_FIRST)", f.Statements[6].Text())
+	assert.Equal(t, "// label", f.Statements[6].Comment())
+	assert.Equal(t, token.Pos{File: "max.asm", Line: 17, Col: 1}, f.EOF.Pos)
+}
+
+func TestParseErrors(t *testing.T) {
+	var diags diag.List
+	f, err := Parse("bad.asm", strings.NewReader("@1\nD=M;JPM\nMM=D\n@2\n"), &diags)
+	assert.Error(t, err)
+	assert.Len(t, diags, 2)
+
+	// the line which failed to tokenize is kept as trivia of the next
+	// statement, the one which failed to parse has no command
+	assert.Len(t, f.Statements, 3)
+	assert.Nil(t, f.Statements[1].Command)
+	assert.Equal(t, token.Trivia{Kind: token.Skipped, Text: "D=M;JPM"}, f.Statements[1].Leading()[0])
+	assert.Len(t, f.Program(), 2)
+}
+
+func TestMacros(t *testing.T) {
+	f, err := Parse("m.asm", strings.NewReader(".macro INC x\n  @x\n  M=M+1\n.endm\n  INC i // i++\n@i\n"), nil)
+	assert.NoError(t, err)
+	assert.Len(t, f.Statements, 6)
+	for _, s := range f.Statements[:5] {
+		assert.True(t, s.Verbatim(), s.Text())
+		assert.Nil(t, s.Command)
+	}
+	assert.Equal(t, "INC i", f.Statements[4].Text())
+	assert.Equal(t, "// i++", f.Statements[4].Comment())
+	assert.False(t, f.Statements[5].Verbatim())
+	assert.Equal(t, command.Program{command.A{Symbol: "i", Pos: token.Pos{File: "m.asm", Line: 6, Col: 1}}}, f.Program())
+
+	// undefined macros are still errors
+	_, err = Parse("m.asm", strings.NewReader("DEC i\n"), nil)
+	assert.EqualError(t, err, "m.asm:1:1: error[E001]: unrecognized symbol: DEC i")
+}
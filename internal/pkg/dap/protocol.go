@@ -0,0 +1,113 @@
+package dap
+
+import "encoding/json"
+
+// Request from the client.
+type Request struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments,omitempty"`
+}
+
+// Response to a request.
+type Response struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Success    bool        `json:"success"`
+	Command    string      `json:"command"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// Event sent to the client.
+type Event struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// Capabilities of the adapter, the initialize response body.
+type Capabilities struct {
+	SupportsConfigurationDoneRequest bool `json:"supportsConfigurationDoneRequest"`
+	SupportsEvaluateForHovers        bool `json:"supportsEvaluateForHovers"`
+	SupportsTerminateRequest         bool `json:"supportsTerminateRequest"`
+	SupportsRestartRequest           bool `json:"supportsRestartRequest"`
+}
+
+// LaunchArguments of the launch request.
+type LaunchArguments struct {
+	Program     string `json:"program"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	NoDebug     bool   `json:"noDebug"`
+}
+
+// Source file.
+type Source struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// SourceBreakpoint requested by the client.
+type SourceBreakpoint struct {
+	Line int `json:"line"`
+}
+
+// SetBreakpointsArguments of the setBreakpoints request.
+type SetBreakpointsArguments struct {
+	Source      Source             `json:"source"`
+	Breakpoints []SourceBreakpoint `json:"breakpoints"`
+}
+
+// Breakpoint as set by the adapter.
+type Breakpoint struct {
+	ID       int    `json:"id"`
+	Verified bool   `json:"verified"`
+	Message  string `json:"message,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Source   Source `json:"source"`
+}
+
+// Thread of execution, the CPU is the only one.
+type Thread struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// StackFrame is a location, the Hack CPU has no call stack so there is only
+// the current instruction.
+type StackFrame struct {
+	ID     int    `json:"id"`
+	Name   string `json:"name"`
+	Source Source `json:"source"`
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+}
+
+// Scope groups variables.
+type Scope struct {
+	Name               string `json:"name"`
+	VariablesReference int    `json:"variablesReference"`
+	Expensive          bool   `json:"expensive"`
+}
+
+// Variable shown to the client.
+type Variable struct {
+	Name               string `json:"name"`
+	Value              string `json:"value"`
+	Type               string `json:"type,omitempty"`
+	MemoryReference    string `json:"memoryReference,omitempty"`
+	VariablesReference int    `json:"variablesReference"`
+}
+
+// VariablesArguments of the variables request.
+type VariablesArguments struct {
+	VariablesReference int `json:"variablesReference"`
+}
+
+// EvaluateArguments of the evaluate request.
+type EvaluateArguments struct {
+	Expression string `json:"expression"`
+}
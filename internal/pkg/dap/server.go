@@ -0,0 +1,336 @@
+// Package dap implements a Debug Adapter Protocol server for Hack assembly,
+// so programs can be debugged from editors such as VS Code.
+package dap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/debug"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/wire"
+)
+
+// Loader assembles the program at path into a debug session.
+type Loader func(path string) (*debug.Session, error)
+
+// variablesReference values for the scopes
+const (
+	registersRef = iota + 1
+	variablesRef
+	predefinedRef
+)
+
+// Server handles one debug session.
+type Server struct {
+	Load Loader
+
+	w       *wire.Writer
+	seq     int
+	session *debug.Session
+	path    string
+	entry   bool
+	noDebug bool
+	lines   []int
+	done    bool
+	// terminated is true once the terminated event is sent
+	terminated bool
+}
+
+// Serve requests read from in, writing responses and events to out, until
+// the client disconnects or in ends.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	r := wire.NewReader(in)
+	s.w = wire.NewWriter(out)
+	for !s.done {
+		body, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req Request
+		if err := json.Unmarshal(body, &req); err != nil {
+			return fmt.Errorf("bad request: %w", err)
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handle a request, errors are only returned for failed writes
+func (s *Server) handle(req Request) error {
+	if req.Command != "initialize" && req.Command != "launch" && req.Command != "setBreakpoints" &&
+		req.Command != "disconnect" && s.session == nil {
+		return s.fail(req, "no program launched")
+	}
+	switch req.Command {
+	case "initialize":
+		return s.respond(req, Capabilities{
+			SupportsConfigurationDoneRequest: true,
+			SupportsEvaluateForHovers:        true,
+			SupportsTerminateRequest:         true,
+			SupportsRestartRequest:           true,
+		})
+	case "launch":
+		var args LaunchArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		session, err := s.Load(args.Program)
+		if err != nil {
+			return s.fail(req, err.Error())
+		}
+		s.session, s.path, s.entry, s.noDebug = session, args.Program, args.StopOnEntry, args.NoDebug
+		s.setBreakpoints(s.lines)
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return s.event("initialized", nil)
+	case "setBreakpoints":
+		var args SetBreakpointsArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		var lines []int
+		for _, b := range args.Breakpoints {
+			lines = append(lines, b.Line)
+		}
+		s.lines = lines
+		return s.respond(req, map[string]interface{}{"breakpoints": s.setBreakpoints(lines)})
+	case "configurationDone":
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		if s.noDebug {
+			s.session.ClearBreakpoints()
+		}
+		if s.entry && !s.noDebug {
+			return s.stopped("entry", "")
+		}
+		// Continue steps before checking breakpoints, so check the first
+		// instruction here
+		if s.session.AtBreakpoint() {
+			return s.stopped("breakpoint", "")
+		}
+		return s.run(s.session.Continue(debug.MaxRun))
+	case "threads":
+		return s.respond(req, map[string]interface{}{"threads": []Thread{{ID: 1, Name: "Hack CPU"}}})
+	case "stackTrace":
+		return s.respond(req, map[string]interface{}{"stackFrames": []StackFrame{s.frame()}, "totalFrames": 1})
+	case "scopes":
+		return s.respond(req, map[string]interface{}{"scopes": []Scope{
+			{Name: "Registers", VariablesReference: registersRef},
+			{Name: "Variables", VariablesReference: variablesRef},
+			{Name: "Predefined", VariablesReference: predefinedRef},
+		}})
+	case "variables":
+		var args VariablesArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		return s.respond(req, map[string]interface{}{"variables": s.variables(args.VariablesReference)})
+	case "evaluate":
+		var args EvaluateArguments
+		if err := json.Unmarshal(req.Arguments, &args); err != nil {
+			return s.fail(req, err.Error())
+		}
+		v, err := s.evaluate(args.Expression)
+		if err != nil {
+			return s.fail(req, err.Error())
+		}
+		return s.respond(req, map[string]interface{}{"result": v, "variablesReference": 0})
+	case "continue":
+		if err := s.respond(req, map[string]interface{}{"allThreadsContinued": true}); err != nil {
+			return err
+		}
+		return s.run(s.session.Continue(debug.MaxRun))
+	case "next", "stepIn", "stepOut":
+		// without a call stack every step is a single instruction
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return s.run(s.session.Step())
+	case "pause":
+		// execution is synchronous, so the program is already paused
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return s.stopped("pause", "")
+	case "restart":
+		s.session.Reset()
+		s.terminated = false
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return s.stopped("entry", "")
+	case "disconnect", "terminate":
+		s.done = true
+		if err := s.respond(req, nil); err != nil {
+			return err
+		}
+		return s.terminate()
+	default:
+		return s.fail(req, "unsupported request "+req.Command)
+	}
+}
+
+// setBreakpoints replaces the breakpoints with ones on source lines
+func (s *Server) setBreakpoints(lines []int) []Breakpoint {
+	var result []Breakpoint
+	if s.session != nil {
+		s.session.ClearBreakpoints()
+	}
+	for i, line := range lines {
+		b := Breakpoint{ID: i + 1, Line: line, Source: s.source()}
+		if s.session == nil {
+			b.Message = "program not launched"
+			result = append(result, b)
+			continue
+		}
+		addr, err := s.session.Break(strconv.Itoa(line))
+		if err != nil {
+			b.Message = err.Error()
+			result = append(result, b)
+			continue
+		}
+		p, _ := s.session.Pos(addr)
+		b.Verified, b.Line = true, p.Line
+		result = append(result, b)
+	}
+	return result
+}
+
+// run reports how execution stopped
+func (s *Server) run(stop debug.Stop) error {
+	switch stop.Reason {
+	case debug.Stepped:
+		return s.stopped("step", "")
+	case debug.Breakpoint:
+		return s.stopped("breakpoint", "")
+	case debug.Watchpoint:
+		return s.stopped("data breakpoint", fmt.Sprintf("RAM[%d] changed", stop.Watch))
+	case debug.Limit:
+		return s.stopped("pause", fmt.Sprintf("still running after %d instructions", debug.MaxRun))
+	}
+	err := s.event("output", map[string]interface{}{
+		"category": "console",
+		"output":   fmt.Sprintf("halted after %d cycles\n", s.session.CPU.Cycles),
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.event("exited", map[string]interface{}{"exitCode": 0}); err != nil {
+		return err
+	}
+	return s.terminate()
+}
+
+// terminate sends the terminated event, unless already sent
+func (s *Server) terminate() error {
+	if s.terminated {
+		return nil
+	}
+	s.terminated = true
+	return s.event("terminated", nil)
+}
+
+func (s *Server) stopped(reason, description string) error {
+	body := map[string]interface{}{"reason": reason, "threadId": 1, "allThreadsStopped": true}
+	if description != "" {
+		body["description"] = description
+	}
+	return s.event("stopped", body)
+}
+
+// frame is the current instruction, named after the label it follows
+func (s *Server) frame() StackFrame {
+	pc := s.session.CPU.PC
+	f := StackFrame{ID: 1, Name: fmt.Sprintf("ROM[%d]", pc), Source: s.source()}
+	if p, ok := s.session.Pos(pc); ok {
+		f.Line, f.Column = p.Line, p.Col
+	}
+	best := -1
+	for _, sym := range s.session.Symbols.Symbols() {
+		if sym.Kind == assembler.Label && sym.Value <= int(pc) && sym.Value > best {
+			best = sym.Value
+			f.Name = fmt.Sprintf("%s+%d", sym.Name, int(pc)-sym.Value)
+		}
+	}
+	return f
+}
+
+func (s *Server) source() Source {
+	return Source{Name: filepath.Base(s.path), Path: s.path}
+}
+
+// variables in a scope
+func (s *Server) variables(ref int) []Variable {
+	c := s.session.CPU
+	if ref == registersRef {
+		return []Variable{
+			{Name: "A", Value: strconv.Itoa(int(int16(c.A)))},
+			{Name: "D", Value: strconv.Itoa(int(int16(c.D)))},
+			{Name: "PC", Value: strconv.Itoa(int(c.PC))},
+		}
+	}
+	kind := assembler.Variable
+	if ref == predefinedRef {
+		kind = assembler.Predefined
+	}
+	result := []Variable{}
+	for _, sym := range s.session.Symbols.Symbols() {
+		if sym.Kind != kind {
+			continue
+		}
+		result = append(result, Variable{
+			Name:            sym.Name,
+			Value:           strconv.Itoa(int(int16(c.RAM[sym.Value]))),
+			Type:            fmt.Sprintf("RAM[%d]", sym.Value),
+			MemoryReference: strconv.Itoa(sym.Value),
+		})
+	}
+	return result
+}
+
+// evaluate a register, label, symbol or RAM address
+func (s *Server) evaluate(expr string) (string, error) {
+	c := s.session.CPU
+	switch expr {
+	case "A":
+		return strconv.Itoa(int(int16(c.A))), nil
+	case "D":
+		return strconv.Itoa(int(int16(c.D))), nil
+	case "PC":
+		return strconv.Itoa(int(c.PC)), nil
+	}
+	if sym, ok := s.session.Symbols.Lookup(expr); ok && sym.Kind == assembler.Label {
+		return fmt.Sprintf("ROM[%d]", sym.Value), nil
+	}
+	addr, err := s.session.RAMAddress(expr)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(int(int16(c.RAM[addr]))), nil
+}
+
+func (s *Server) respond(req Request, body interface{}) error {
+	s.seq++
+	return s.w.Write(Response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+}
+
+func (s *Server) fail(req Request, message string) error {
+	s.seq++
+	return s.w.Write(Response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: message})
+}
+
+func (s *Server) event(name string, body interface{}) error {
+	s.seq++
+	return s.w.Write(Event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
@@ -0,0 +1,217 @@
+package dap
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/debug"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+const count = `    @i
+    M=1
+(LOOP)
+    @i
+    M=M+1
+    D=M
+    @3
+    D=D-A
+    @LOOP
+    D;JLT
+(END)
+    @END
+    0;JMP
+`
+
+func load(path string) (*debug.Session, error) {
+	tokens, err := lex.TokenizeFile(path, strings.NewReader(count), nil)
+	if err != nil {
+		return nil, err
+	}
+	program, err := parser.Parse(tokens, nil)
+	if err != nil {
+		return nil, err
+	}
+	text, symbols, err := assembler.Assemble(program, nil)
+	if err != nil {
+		return nil, err
+	}
+	return debug.New(program, text, symbols, strings.Split(count, "\n"))
+}
+
+// message is any response or event
+type message struct {
+	Type       string          `json:"type"`
+	Command    string          `json:"command"`
+	Event      string          `json:"event"`
+	Success    bool            `json:"success"`
+	RequestSeq int             `json:"request_seq"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+func (m message) String() string {
+	if m.Type == "event" {
+		return "event " + m.Event
+	}
+	if !m.Success {
+		return m.Command + " failed: " + m.Message
+	}
+	return m.Command
+}
+
+// serve sends requests, given as command and arguments pairs, returning
+// the messages written back
+func serve(t *testing.T, requests ...interface{}) []message {
+	var in bytes.Buffer
+	w := wire.NewWriter(&in)
+	for i := 0; i < len(requests); i += 2 {
+		args, _ := json.Marshal(requests[i+1])
+		assert.NoError(t, w.Write(Request{Seq: i/2 + 1, Type: "request", Command: requests[i].(string), Arguments: args}))
+	}
+	var out bytes.Buffer
+	s := &Server{Load: load}
+	assert.NoError(t, s.Serve(&in, &out))
+
+	var result []message
+	r := wire.NewReader(&out)
+	for {
+		body, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		var m message
+		assert.NoError(t, json.Unmarshal(body, &m))
+		result = append(result, m)
+	}
+	return result
+}
+
+func names(messages []message) []string {
+	var result []string
+	for _, m := range messages {
+		result = append(result, m.String())
+	}
+	return result
+}
+
+func TestSession(t *testing.T) {
+	out := serve(t,
+		"initialize", map[string]string{"adapterID": "hack"},
+		"launch", LaunchArguments{Program: "count.asm"},
+		"setBreakpoints", SetBreakpointsArguments{Source: Source{Path: "count.asm"}, Breakpoints: []SourceBreakpoint{{Line: 3}, {Line: 99}}},
+		"configurationDone", nil,
+		"stackTrace", map[string]int{"threadId": 1},
+		"variables", VariablesArguments{VariablesReference: variablesRef},
+		"next", nil,
+		"evaluate", EvaluateArguments{Expression: "i"},
+		"evaluate", EvaluateArguments{Expression: "LOOP"},
+		"evaluate", EvaluateArguments{Expression: "nope"},
+		"continue", nil,
+		"setBreakpoints", SetBreakpointsArguments{Source: Source{Path: "count.asm"}},
+		"continue", nil,
+		"disconnect", nil,
+	)
+	assert.Equal(t, []string{
+		"initialize",
+		"launch", "event initialized",
+		"setBreakpoints",
+		"configurationDone", "event stopped",
+		"stackTrace",
+		"variables",
+		"next", "event stopped",
+		"evaluate", "evaluate",
+		"evaluate failed: no symbol nope",
+		"continue", "event stopped",
+		"setBreakpoints",
+		"continue", "event output", "event exited", "event terminated",
+		"disconnect",
+	}, names(out))
+
+	var bps struct{ Breakpoints []Breakpoint }
+	assert.NoError(t, json.Unmarshal(out[3].Body, &bps))
+	assert.Equal(t, []Breakpoint{
+		{ID: 1, Verified: true, Line: 4, Source: Source{Name: "count.asm", Path: "count.asm"}},
+		{ID: 2, Message: "no instruction at or after line 99", Line: 99, Source: Source{Name: "count.asm", Path: "count.asm"}},
+	}, bps.Breakpoints)
+
+	assert.JSONEq(t, `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`, string(out[5].Body))
+	assert.JSONEq(t, `{"stackFrames":[{"id":1,"name":"LOOP+0","source":{"name":"count.asm","path":"count.asm"},"line":4,"column":5}],"totalFrames":1}`, string(out[6].Body))
+	assert.JSONEq(t, `{"variables":[{"name":"i","value":"1","type":"RAM[16]","memoryReference":"16","variablesReference":0}]}`, string(out[7].Body))
+	assert.JSONEq(t, `{"reason":"step","threadId":1,"allThreadsStopped":true}`, string(out[9].Body))
+	assert.JSONEq(t, `{"result":"1","variablesReference":0}`, string(out[10].Body))
+	assert.JSONEq(t, `{"result":"ROM[2]","variablesReference":0}`, string(out[11].Body))
+	assert.JSONEq(t, `{"exitCode":0}`, string(out[18].Body))
+}
+
+func TestBreakpointOnFirstInstruction(t *testing.T) {
+	out := serve(t,
+		"launch", LaunchArguments{Program: "count.asm"},
+		"setBreakpoints", SetBreakpointsArguments{Source: Source{Path: "count.asm"}, Breakpoints: []SourceBreakpoint{{Line: 1}}},
+		"configurationDone", nil,
+		"variables", VariablesArguments{VariablesReference: registersRef},
+		"continue", nil,
+		"terminate", nil,
+	)
+	assert.Equal(t, []string{
+		"launch", "event initialized",
+		"setBreakpoints",
+		"configurationDone", "event stopped",
+		"variables",
+		"continue", "event output", "event exited", "event terminated",
+		"terminate",
+	}, names(out))
+	assert.JSONEq(t, `{"reason":"breakpoint","threadId":1,"allThreadsStopped":true}`, string(out[4].Body))
+	assert.JSONEq(t, `{"variables":[{"name":"A","value":"0","variablesReference":0},{"name":"D","value":"0","variablesReference":0},{"name":"PC","value":"0","variablesReference":0}]}`, string(out[5].Body))
+
+	// without a halt, disconnect terminates
+	out = serve(t,
+		"launch", LaunchArguments{Program: "count.asm", StopOnEntry: true},
+		"configurationDone", nil,
+		"disconnect", nil,
+	)
+	assert.Equal(t, []string{"launch", "event initialized", "configurationDone", "event stopped", "disconnect", "event terminated"}, names(out))
+}
+
+func TestStopOnEntry(t *testing.T) {
+	out := serve(t,
+		"threads", nil,
+		"launch", LaunchArguments{Program: "count.asm", StopOnEntry: true},
+		"configurationDone", nil,
+		"scopes", map[string]int{"frameId": 1},
+		"variables", VariablesArguments{VariablesReference: registersRef},
+		"stepIn", nil,
+		"stepIn", nil,
+		"variables", VariablesArguments{VariablesReference: registersRef},
+		"restart", nil,
+		"variables", VariablesArguments{VariablesReference: registersRef},
+		"bogus", nil,
+	)
+	assert.Equal(t, []string{
+		"threads failed: no program launched",
+		"launch", "event initialized",
+		"configurationDone", "event stopped",
+		"scopes",
+		"variables",
+		"stepIn", "event stopped",
+		"stepIn", "event stopped",
+		"variables",
+		"restart", "event stopped",
+		"variables",
+		"bogus failed: unsupported request bogus",
+	}, names(out))
+	assert.JSONEq(t, `{"reason":"entry","threadId":1,"allThreadsStopped":true}`, string(out[4].Body))
+	registers := `{"variables":[{"name":"A","value":"%s","variablesReference":0},{"name":"D","value":"0","variablesReference":0},{"name":"PC","value":"%s","variablesReference":0}]}`
+	assert.JSONEq(t, fmt.Sprintf(registers, "0", "0"), string(out[6].Body))
+	assert.JSONEq(t, fmt.Sprintf(registers, "16", "2"), string(out[11].Body))
+	assert.JSONEq(t, fmt.Sprintf(registers, "0", "0"), string(out[14].Body))
+}
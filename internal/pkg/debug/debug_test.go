@@ -0,0 +1,175 @@
+package debug
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+// sum adds 1..R0 into R1
+const sum = `// sum 1..R0 into R1
+    @i
+    M=1
+    @R1
+    M=0
+(LOOP)
+    @i
+    D=M
+    @R0
+    D=D-M
+    @END
+    D;JGT
+    @i
+    D=M
+    @R1
+    M=D+M
+    @i
+    M=M+1
+    @LOOP
+    0;JMP
+(END)
+    @END
+    0;JMP
+`
+
+func session(t *testing.T, src string) *Session {
+	tokens, err := lex.TokenizeFile("sum.asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	text, symbols, err := assembler.Assemble(program, nil)
+	assert.NoError(t, err)
+	s, err := New(program, text, symbols, strings.Split(src, "\n"))
+	assert.NoError(t, err)
+	return s
+}
+
+func TestBreakpoints(t *testing.T) {
+	s := session(t, sum)
+	s.CPU.RAM[0] = 3
+
+	addr, err := s.Break("LOOP")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(4), addr)
+
+	stop := s.Continue(1000)
+	assert.Equal(t, Stop{Reason: Breakpoint, PC: 4}, stop)
+	p, _ := s.Pos(stop.PC)
+	assert.Equal(t, "sum.asm:7:5", p.String())
+
+	// a line without an instruction breaks on the next one
+	addr, err = s.Break("6")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(4), addr)
+	addr, err = s.Break("*12")
+	assert.NoError(t, err)
+	assert.Equal(t, uint16(12), addr)
+	assert.Equal(t, []uint16{4, 12}, s.Breakpoints())
+
+	assert.Equal(t, Breakpoint, s.Continue(1000).Reason)
+	assert.Equal(t, uint16(12), s.CPU.PC)
+	assert.Equal(t, uint16(0), s.CPU.RAM[1])
+	assert.Equal(t, Breakpoint, s.Continue(1000).Reason)
+	assert.Equal(t, uint16(4), s.CPU.PC)
+	assert.Equal(t, uint16(1), s.CPU.RAM[1])
+
+	s.ClearBreakpoints()
+	assert.Equal(t, Halted, s.Continue(1000).Reason)
+	assert.Equal(t, uint16(6), s.CPU.RAM[1])
+
+	_, err = s.Break("NOWHERE")
+	assert.Error(t, err)
+	_, err = s.Break("i")
+	assert.Error(t, err)
+}
+
+func TestWatchpoints(t *testing.T) {
+	s := session(t, sum)
+	s.CPU.RAM[0] = 2
+	addr, err := s.Watch("R1")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, addr)
+
+	// M=0 writes the value already there
+	stop := s.Continue(1000)
+	assert.Equal(t, Watchpoint, stop.Reason)
+	assert.Equal(t, 1, stop.Watch)
+	assert.Equal(t, uint16(0), stop.Old)
+	assert.Equal(t, uint16(1), s.CPU.RAM[1])
+
+	stop = s.Continue(1000)
+	assert.Equal(t, uint16(1), stop.Old)
+	assert.Equal(t, uint16(3), s.CPU.RAM[1])
+	assert.Equal(t, Halted, s.Continue(1000).Reason)
+
+	s.Reset()
+	assert.Equal(t, uint16(0), s.CPU.PC)
+	assert.Equal(t, uint16(0), s.CPU.RAM[1])
+}
+
+func TestRAMAddress(t *testing.T) {
+	s := session(t, sum)
+	testCases := map[string]int{
+		"i":         16,
+		"R1":        1,
+		"SCREEN":    0x4000,
+		"RAM[17]":   17,
+		"0x10":      16,
+		"RAM[0x20]": 32,
+	}
+	for spec, expected := range testCases {
+		addr, err := s.RAMAddress(spec)
+		assert.NoError(t, err, spec)
+		assert.Equal(t, expected, addr, spec)
+	}
+	for _, spec := range []string{"LOOP", "nope", "40000", "-1"} {
+		_, err := s.RAMAddress(spec)
+		assert.Error(t, err, spec)
+	}
+	assert.Equal(t, "i", s.Name(16))
+	assert.Equal(t, "SP", s.Name(0))
+}
+
+func TestREPL(t *testing.T) {
+	s := session(t, sum)
+	in := strings.NewReader(`break END
+print PC
+watch i
+c
+c
+p i
+set
+x/2 R0
+info break
+info regs
+delete
+continue
+q
+`)
+	var out bytes.Buffer
+	assert.NoError(t, s.REPL(in, &out))
+	expected := `type "help" for commands
+ROM[0] sum.asm:2:5: @i
+(hdb) breakpoint at ROM[18] sum.asm:22:5: @END
+(hdb) PC = 0 sum.asm:2:5: @i
+(hdb) watching RAM[16]
+(hdb) watchpoint RAM[16] i: 0 -> 1
+ROM[2] sum.asm:4:5: @R1
+(hdb) breakpoint ROM[18]
+ROM[18] sum.asm:22:5: @END
+(hdb) i = 1 (RAM[16])
+(hdb) unknown command set, try help
+(hdb) RAM[0]	0	0x0000	SP
+RAM[1]	0	0x0000	LCL
+(hdb) ROM[18] sum.asm:22:5: @END
+(hdb) A=18 D=1 PC=18 cycles=10
+(hdb) (hdb) halted after 11 cycles
+ROM[19] sum.asm:23:5: 0;JMP
+(hdb) `
+	assert.Equal(t, expected, out.String())
+}
@@ -0,0 +1,232 @@
+package debug
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cpu"
+)
+
+// MaxRun is how many instructions continue runs before giving up.
+const MaxRun = 10000000
+
+const help = `commands:
+  break LABEL|LINE|*ADDR   set a breakpoint on a label, source line or ROM address
+  delete                   delete all breakpoints
+  watch SYMBOL|ADDR        stop when a RAM cell changes
+  step [N], s              execute N instructions (default 1)
+  continue, c              run until a breakpoint, watchpoint or halt
+  print EXPR, p            print A, D, PC, a symbol, RAM[n] or a number
+  x/N ADDR                 examine N RAM cells from a symbol or address
+  info break|watch|regs    list breakpoints, watchpoints or registers
+  list [LINE]              show source around the current or given line
+  reset                    restart the program with cleared RAM
+  quit, q                  exit`
+
+// REPL reads debugger commands from in, writing results to out, until quit
+// or the end of input.
+func (s *Session) REPL(in io.Reader, out io.Writer) error {
+	fmt.Fprintln(out, `type "help" for commands`)
+	s.where(out)
+	scanner := bufio.NewScanner(in)
+	for {
+		fmt.Fprint(out, "(hdb) ")
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "quit" || fields[0] == "q" {
+			return nil
+		}
+		if err := s.exec(out, fields[0], fields[1:]); err != nil {
+			fmt.Fprintln(out, err)
+		}
+	}
+}
+
+func (s *Session) exec(out io.Writer, cmd string, args []string) error {
+	switch {
+	case cmd == "help" || cmd == "h":
+		fmt.Fprintln(out, help)
+	case cmd == "break" || cmd == "b":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: break LABEL|LINE|*ADDR")
+		}
+		addr, err := s.Break(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "breakpoint at ROM[%d] %s\n", addr, s.describe(addr))
+	case cmd == "delete" || cmd == "d":
+		s.ClearBreakpoints()
+	case cmd == "watch" || cmd == "w":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: watch SYMBOL|ADDR")
+		}
+		addr, err := s.Watch(args[0])
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "watching RAM[%d]\n", addr)
+	case cmd == "step" || cmd == "s":
+		n := 1
+		if len(args) == 1 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil || v < 1 {
+				return fmt.Errorf("usage: step [N]")
+			}
+			n = v
+		}
+		stop := Stop{Reason: Stepped}
+		for i := 0; i < n && stop.Reason == Stepped; i++ {
+			stop = s.Step()
+		}
+		s.report(out, stop)
+	case cmd == "continue" || cmd == "c":
+		s.report(out, s.Continue(MaxRun))
+	case cmd == "print" || cmd == "p":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: print EXPR")
+		}
+		return s.print(out, args[0])
+	case strings.HasPrefix(cmd, "x"):
+		n := 1
+		if strings.HasPrefix(cmd, "x/") {
+			v, err := strconv.Atoi(cmd[2:])
+			if err != nil || v < 1 {
+				return fmt.Errorf("usage: x/N ADDR")
+			}
+			n = v
+		} else if cmd != "x" {
+			return fmt.Errorf("unknown command %s, try help", cmd)
+		}
+		if len(args) != 1 {
+			return fmt.Errorf("usage: x/N ADDR")
+		}
+		addr, err := s.RAMAddress(args[0])
+		if err != nil {
+			return err
+		}
+		for a := addr; a < addr+n && a < cpu.RAMSize; a++ {
+			v := s.CPU.RAM[a]
+			fmt.Fprintf(out, "RAM[%d]\t%d\t0x%04x\t%s\n", a, int16(v), v, s.Name(a))
+		}
+	case cmd == "info" || cmd == "i":
+		if len(args) != 1 {
+			return fmt.Errorf("usage: info break|watch|regs")
+		}
+		switch args[0] {
+		case "break", "b":
+			for _, addr := range s.Breakpoints() {
+				fmt.Fprintf(out, "ROM[%d] %s\n", addr, s.describe(addr))
+			}
+		case "watch", "w":
+			for _, addr := range s.Watches() {
+				fmt.Fprintf(out, "RAM[%d] %s = %d\n", addr, s.Name(addr), int16(s.CPU.RAM[addr]))
+			}
+		case "regs", "registers", "r":
+			fmt.Fprintf(out, "A=%d D=%d PC=%d cycles=%d\n", int16(s.CPU.A), int16(s.CPU.D), s.CPU.PC, s.CPU.Cycles)
+		default:
+			return fmt.Errorf("usage: info break|watch|regs")
+		}
+	case cmd == "list" || cmd == "l":
+		line := 0
+		if p, ok := s.Pos(s.CPU.PC); ok {
+			line = p.Line
+		}
+		if len(args) == 1 {
+			v, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("usage: list [LINE]")
+			}
+			line = v
+		}
+		s.list(out, line)
+	case cmd == "reset":
+		s.Reset()
+		s.where(out)
+	default:
+		return fmt.Errorf("unknown command %s, try help", cmd)
+	}
+	return nil
+}
+
+// print the value of a register, symbol, RAM cell or number
+func (s *Session) print(out io.Writer, expr string) error {
+	switch expr {
+	case "A":
+		fmt.Fprintf(out, "A = %d\n", int16(s.CPU.A))
+		return nil
+	case "D":
+		fmt.Fprintf(out, "D = %d\n", int16(s.CPU.D))
+		return nil
+	case "PC":
+		fmt.Fprintf(out, "PC = %d %s\n", s.CPU.PC, s.describe(s.CPU.PC))
+		return nil
+	}
+	if sym, ok := s.Symbols.Lookup(expr); ok && sym.Kind == assembler.Label {
+		fmt.Fprintf(out, "%s = ROM[%d] %s\n", expr, sym.Value, s.describe(uint16(sym.Value)))
+		return nil
+	}
+	addr, err := s.RAMAddress(expr)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s = %d (RAM[%d])\n", expr, int16(s.CPU.RAM[addr]), addr)
+	return nil
+}
+
+// report why execution stopped and where
+func (s *Session) report(out io.Writer, stop Stop) {
+	switch stop.Reason {
+	case Breakpoint:
+		fmt.Fprintf(out, "breakpoint ROM[%d]\n", stop.PC)
+	case Watchpoint:
+		fmt.Fprintf(out, "watchpoint RAM[%d] %s: %d -> %d\n", stop.Watch, s.Name(stop.Watch), int16(stop.Old), int16(s.CPU.RAM[stop.Watch]))
+	case Halted:
+		fmt.Fprintf(out, "halted after %d cycles\n", s.CPU.Cycles)
+	case Limit:
+		fmt.Fprintf(out, "still running after %d instructions\n", MaxRun)
+	}
+	s.where(out)
+}
+
+// where prints the instruction about to execute
+func (s *Session) where(out io.Writer) {
+	fmt.Fprintf(out, "ROM[%d] %s\n", s.CPU.PC, s.describe(s.CPU.PC))
+}
+
+// describe a ROM address by its source position and text
+func (s *Session) describe(addr uint16) string {
+	p, ok := s.Pos(addr)
+	if !ok {
+		return "(past end of program)"
+	}
+	return fmt.Sprintf("%v: %s", p, strings.TrimSpace(s.Line(p.Line)))
+}
+
+// list source lines around line, marking the current instruction
+func (s *Session) list(out io.Writer, line int) {
+	current := -1
+	if p, ok := s.Pos(s.CPU.PC); ok {
+		current = p.Line
+	}
+	for n := line - 5; n <= line+5; n++ {
+		if n < 1 || n > s.Lines() {
+			continue
+		}
+		mark := "  "
+		if n == current {
+			mark = "=>"
+		}
+		fmt.Fprintf(out, "%s %4d  %s\n", mark, n, s.Line(n))
+	}
+}
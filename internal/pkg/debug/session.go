@@ -0,0 +1,271 @@
+package debug
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cpu"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/hack"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Reason a run stopped
+type Reason int
+
+const (
+	Stepped Reason = iota
+	Breakpoint
+	Watchpoint
+	Halted
+	Limit
+)
+
+// Stop describes where and why execution stopped.
+type Stop struct {
+	Reason Reason
+	PC     uint16
+	// Watch is the RAM address which changed, for Watchpoint
+	Watch int
+	Old   uint16
+}
+
+// Session runs an assembled program on a simulated CPU, mapping ROM
+// addresses back to source positions.
+type Session struct {
+	CPU     *cpu.CPU
+	Symbols *assembler.SymbolTable
+
+	program     []uint16
+	positions   []token.Pos
+	source      []string
+	breakpoints map[uint16]bool
+	watches     map[int]uint16
+}
+
+// New returns a session for program, which assembled to instructions with
+// symbols. source is the program text, one entry per line.
+func New(program command.Program, instructions []string, symbols *assembler.SymbolTable, source []string) (*Session, error) {
+	words, err := hack.Words(instructions)
+	if err != nil {
+		return nil, err
+	}
+	c, err := cpu.New(words)
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		CPU:         c,
+		Symbols:     symbols,
+		program:     words,
+		source:      source,
+		breakpoints: map[uint16]bool{},
+		watches:     map[int]uint16{},
+	}
+	// instructions from macros are placed at their call
+	for _, cmd := range program {
+		switch cmd := cmd.(type) {
+		case command.A:
+			s.positions = append(s.positions, cmd.Pos.Site())
+		case command.C:
+			s.positions = append(s.positions, cmd.Pos.Site())
+		}
+	}
+	if len(s.positions) != len(words) {
+		return nil, fmt.Errorf("program has %d instructions but %d words", len(s.positions), len(words))
+	}
+	return s, nil
+}
+
+// Reset the CPU to start the program again, clearing RAM.
+func (s *Session) Reset() {
+	s.CPU, _ = cpu.New(s.program)
+	for addr := range s.watches {
+		s.watches[addr] = 0
+	}
+}
+
+// Pos returns the source position of the instruction at a ROM address.
+func (s *Session) Pos(addr uint16) (token.Pos, bool) {
+	if int(addr) >= len(s.positions) {
+		return token.Pos{}, false
+	}
+	return s.positions[addr], true
+}
+
+// Line returns the source text of a line, counting from 1.
+func (s *Session) Line(n int) string {
+	if n < 1 || n > len(s.source) {
+		return ""
+	}
+	return s.source[n-1]
+}
+
+// Lines returns the number of source lines.
+func (s *Session) Lines() int {
+	return len(s.source)
+}
+
+// Address returns the ROM address of the first instruction at or after a
+// source line.
+func (s *Session) Address(line int) (uint16, bool) {
+	for addr, p := range s.positions {
+		if p.Line >= line {
+			return uint16(addr), true
+		}
+	}
+	return 0, false
+}
+
+// Break sets a breakpoint given as a label, a source line number, or *n for
+// a ROM address, returning the ROM address.
+func (s *Session) Break(spec string) (uint16, error) {
+	addr, err := s.rom(spec)
+	if err != nil {
+		return 0, err
+	}
+	s.breakpoints[addr] = true
+	return addr, nil
+}
+
+// rom resolves a breakpoint spec to a ROM address
+func (s *Session) rom(spec string) (uint16, error) {
+	if strings.HasPrefix(spec, "*") {
+		v, err := strconv.ParseUint(spec[1:], 0, 15)
+		if err != nil {
+			return 0, fmt.Errorf("bad ROM address %s", spec)
+		}
+		return uint16(v), nil
+	}
+	if n, err := strconv.Atoi(spec); err == nil {
+		addr, ok := s.Address(n)
+		if !ok {
+			return 0, fmt.Errorf("no instruction at or after line %d", n)
+		}
+		return addr, nil
+	}
+	sym, ok := s.Symbols.Lookup(spec)
+	if !ok || sym.Kind != assembler.Label {
+		return 0, fmt.Errorf("no label %s", spec)
+	}
+	return uint16(sym.Value), nil
+}
+
+// ClearBreakpoints removes all breakpoints.
+func (s *Session) ClearBreakpoints() {
+	s.breakpoints = map[uint16]bool{}
+}
+
+// Breakpoints returns the ROM addresses with breakpoints, in order.
+func (s *Session) Breakpoints() []uint16 {
+	var result []uint16
+	for addr := range s.breakpoints {
+		result = append(result, addr)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i] < result[j] })
+	return result
+}
+
+// AtBreakpoint returns true if there is a breakpoint at PC.
+func (s *Session) AtBreakpoint() bool {
+	return s.breakpoints[s.CPU.PC]
+}
+
+// Watch stops execution whenever the RAM cell named by spec changes,
+// returning its address.
+func (s *Session) Watch(spec string) (int, error) {
+	addr, err := s.RAMAddress(spec)
+	if err != nil {
+		return 0, err
+	}
+	s.watches[addr] = s.CPU.RAM[addr]
+	return addr, nil
+}
+
+// Watches returns the watched RAM addresses, in order.
+func (s *Session) Watches() []int {
+	var result []int
+	for addr := range s.watches {
+		result = append(result, addr)
+	}
+	sort.Ints(result)
+	return result
+}
+
+// Step executes one instruction.
+func (s *Session) Step() Stop {
+	if s.CPU.Halted() {
+		return Stop{Reason: Halted, PC: s.CPU.PC}
+	}
+	s.CPU.Step()
+	if stop, ok := s.watched(); ok {
+		return stop
+	}
+	return Stop{Reason: Stepped, PC: s.CPU.PC}
+}
+
+// Continue runs until a breakpoint or watchpoint, the program halts, or max
+// instructions have run.
+func (s *Session) Continue(max int) Stop {
+	for n := 0; n < max; n++ {
+		stop := s.Step()
+		if stop.Reason != Stepped {
+			return stop
+		}
+		if s.breakpoints[s.CPU.PC] {
+			return Stop{Reason: Breakpoint, PC: s.CPU.PC}
+		}
+	}
+	return Stop{Reason: Limit, PC: s.CPU.PC}
+}
+
+// watched checks for a change to any watched RAM cell
+func (s *Session) watched() (Stop, bool) {
+	for _, addr := range s.Watches() {
+		old := s.watches[addr]
+		if v := s.CPU.RAM[addr]; v != old {
+			s.watches[addr] = v
+			return Stop{Reason: Watchpoint, PC: s.CPU.PC, Watch: addr, Old: old}, true
+		}
+	}
+	return Stop{}, false
+}
+
+// RAMAddress resolves a RAM address given as a number, RAM[n] or a symbol.
+func (s *Session) RAMAddress(spec string) (int, error) {
+	if strings.HasPrefix(spec, "RAM[") && strings.HasSuffix(spec, "]") {
+		spec = spec[4 : len(spec)-1]
+	}
+	if v, err := strconv.ParseInt(spec, 0, 32); err == nil {
+		if v < 0 || v >= cpu.RAMSize {
+			return 0, fmt.Errorf("RAM address %s out of range", spec)
+		}
+		return int(v), nil
+	}
+	sym, ok := s.Symbols.Lookup(spec)
+	if !ok {
+		return 0, fmt.Errorf("no symbol %s", spec)
+	}
+	if sym.Kind == assembler.Label {
+		return 0, fmt.Errorf("%s is a label, at ROM address %d", spec, sym.Value)
+	}
+	return sym.Value, nil
+}
+
+// Name returns the variable or predefined symbol naming a RAM address.
+func (s *Session) Name(addr int) string {
+	name := ""
+	for _, sym := range s.Symbols.Symbols() {
+		if sym.Value != addr || sym.Kind == assembler.Label {
+			continue
+		}
+		// prefer variables, then the first predefined name
+		if sym.Kind == assembler.Variable || name == "" {
+			name = sym.Name
+		}
+	}
+	return name
+}
@@ -0,0 +1,174 @@
+package diag
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Severity of a diagnostic
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Code identifies the kind of problem a diagnostic reports.
+type Code string
+
+// Known diagnostic codes
+const (
+	Syntax      Code = "E001"
+	UnknownComp Code = "E002"
+	UnknownJump Code = "E003"
+	BadDest     Code = "E004"
+	MissingComp Code = "E005"
+	BadAddress  Code = "E006"
+	OutOfRAM    Code = "E007"
+	DupLabel    Code = "E008"
+	Redefined   Code = "E009"
+	Macro       Code = "E010"
+	Include     Code = "E011"
+	Link        Code = "E012"
+	Expr        Code = "E013"
+
+	LabelAsVar   Code = "W001"
+	UnusedLabel  Code = "W002"
+	UnreadVar    Code = "W003"
+	UninitVar    Code = "W004"
+	Unreachable  Code = "W005"
+	NoHalt       Code = "W006"
+	ComputedJump Code = "W007"
+	NonCanonical Code = "W008"
+	VarLikeLabel Code = "W009"
+)
+
+// Diagnostic is a problem found in source, positioned at the offending token.
+type Diagnostic struct {
+	Severity   Severity
+	Pos        token.Pos
+	Code       Code
+	Message    string
+	Suggestion string
+}
+
+// Errorf returns an error diagnostic at pos.
+func Errorf(pos token.Pos, code Code, format string, a ...interface{}) Diagnostic {
+	return Diagnostic{Severity: Error, Pos: pos, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Warnf returns a warning diagnostic at pos.
+func Warnf(pos token.Pos, code Code, format string, a ...interface{}) Diagnostic {
+	return Diagnostic{Severity: Warning, Pos: pos, Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// Suggest returns a copy of d with a suggested replacement.
+func (d Diagnostic) Suggest(s string) Diagnostic {
+	d.Suggestion = s
+	return d
+}
+
+// Error formats the diagnostic as file:line:col: severity[code]: message,
+// ending with the macro calls the position was expanded from, if any.
+func (d Diagnostic) Error() string {
+	var b strings.Builder
+	if d.Pos.Line > 0 {
+		pos := d.Pos
+		pos.Expansion = nil
+		fmt.Fprintf(&b, "%v: ", pos)
+	}
+	b.WriteString(d.Severity.String())
+	if d.Code != "" {
+		fmt.Fprintf(&b, "[%s]", d.Code)
+	}
+	fmt.Fprintf(&b, ": %s", d.Message)
+	if d.Suggestion != "" {
+		fmt.Fprintf(&b, " (did you mean %s?)", d.Suggestion)
+	}
+	b.WriteString(d.Pos.Trace())
+	return b.String()
+}
+
+// List collects diagnostics across the lex, parse and assemble stages.
+type List []Diagnostic
+
+// Add appends err to the list. Diagnostics and lists are added as-is, any
+// other error becomes an unpositioned error diagnostic.
+func (l *List) Add(err error) {
+	switch e := err.(type) {
+	case nil:
+	case Diagnostic:
+		*l = append(*l, e)
+	case List:
+		*l = append(*l, e...)
+	default:
+		*l = append(*l, Diagnostic{Severity: Error, Message: err.Error()})
+	}
+}
+
+// HasErrors returns true if any diagnostic is an error.
+func (l List) HasErrors() bool {
+	for _, d := range l {
+		if d.Severity == Error {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns the list as an error if it contains any errors, else nil.
+func (l List) Err() error {
+	if !l.HasErrors() {
+		return nil
+	}
+	return l
+}
+
+// Error joins all diagnostics, one per line.
+func (l List) Error() string {
+	s := make([]string, len(l))
+	for i, d := range l {
+		s[i] = d.Error()
+	}
+	return strings.Join(s, "\n")
+}
+
+// Sort diagnostics by position, keeping stage order for equal positions.
+// Expanded diagnostics sort by the macro call or include they came from.
+func (l List) Sort() {
+	sort.SliceStable(l, func(i, j int) bool {
+		if a, b := l[i].Pos.Site(), l[j].Pos.Site(); a != b {
+			return less(a, b)
+		}
+		return less(l[i].Pos, l[j].Pos)
+	})
+}
+
+// less orders positions by file, line and column
+func less(a, b token.Pos) bool {
+	if a.File != b.File {
+		return a.File < b.File
+	}
+	if a.Line != b.Line {
+		return a.Line < b.Line
+	}
+	return a.Col < b.Col
+}
+
+// Print writes each diagnostic on its own line.
+func (l List) Print(w io.Writer) {
+	for _, d := range l {
+		fmt.Fprintln(w, d.Error())
+	}
+}
@@ -0,0 +1,59 @@
+package diag
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+func TestDiagnosticError(t *testing.T) {
+	d := Errorf(token.Pos{File: "a.asm", Line: 3, Col: 2}, Syntax, "bad %s", "thing")
+	assert.Equal(t, "a.asm:3:2: error[E001]: bad thing", d.Error())
+	assert.Equal(t, "a.asm:3:2: error[E001]: bad thing (did you mean good?)", d.Suggest("good").Error())
+
+	w := Warnf(token.Pos{Line: 1, Col: 1}, Syntax, "meh")
+	assert.Equal(t, "1:1: warning[E001]: meh", w.Error())
+
+	call := token.Pos{File: "a.asm", Line: 20, Col: 1}
+	m := Errorf(token.Pos{File: "a.asm", Line: 4, Col: 5, Expansion: &call}, Macro, "bad")
+	assert.Equal(t, "a.asm:4:5: error[E010]: bad (via a.asm:20:1)", m.Error())
+}
+
+func TestList(t *testing.T) {
+	var l List
+	assert.NoError(t, l.Err())
+
+	l.Add(nil)
+	l.Add(Warnf(token.Pos{Line: 9, Col: 1}, Syntax, "later"))
+	assert.NoError(t, l.Err())
+
+	l.Add(List{Errorf(token.Pos{Line: 2, Col: 5}, UnknownComp, "first")})
+	l.Add(errors.New("plain"))
+	assert.Len(t, l, 3)
+	assert.Error(t, l.Err())
+
+	// macro bodies sort by their call
+	call := token.Pos{Line: 5, Col: 1}
+	l.Add(Errorf(token.Pos{Line: 1, Col: 1, Expansion: &call}, Macro, "expanded"))
+
+	l.Sort()
+	assert.Equal(t, "error: plain\n2:5: error[E002]: first\n1:1: error[E010]: expanded (via 5:1)\n9:1: warning[E001]: later", l.Error())
+}
+
+func TestClosest(t *testing.T) {
+	jumps := []string{"JEQ", "JGE", "JGT", "JLE", "JLT", "JMP", "JNE"}
+	testCases := map[string]string{
+		"JPM":  "JMP",
+		"JMPP": "JMP",
+		"jmp":  "",
+		"JGX":  "JGE",
+		"XYZ":  "",
+		"":     "",
+	}
+	for s, expected := range testCases {
+		assert.Equal(t, expected, Closest(s, jumps), s)
+	}
+}
@@ -0,0 +1,224 @@
+// Package diff compares texts line by line, printing unified diffs.
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op is an edit operation on one line.
+type Op int
+
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit applies Op to Line.
+type Edit struct {
+	Op   Op
+	Line string
+}
+
+// Lines returns the edits turning a into b, using Myers' algorithm in linear
+// space so large files with many changes stay cheap.
+func Lines(a, b []string) []Edit {
+	d := &differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b   []string
+	edits  []Edit
+	vf, vb []int
+}
+
+// compare a[aLo:aHi] with b[bLo:bHi], appending edits in order
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// common prefix and suffix
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.edits = append(d.edits, Edit{Equal, d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for _, s := range d.b[bLo:bHi] {
+			d.edits = append(d.edits, Edit{Insert, s})
+		}
+	case bLo == bHi || disjoint(d.a[aLo:aHi], d.b[bLo:bHi]):
+		for _, s := range d.a[aLo:aHi] {
+			d.edits = append(d.edits, Edit{Delete, s})
+		}
+		for _, s := range d.b[bLo:bHi] {
+			d.edits = append(d.edits, Edit{Insert, s})
+		}
+	default:
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for _, s := range d.a[x:u] {
+			d.edits = append(d.edits, Edit{Equal, s})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for _, s := range d.a[aHi : aHi+suffix] {
+		d.edits = append(d.edits, Edit{Equal, s})
+	}
+}
+
+// disjoint returns true if a and b have no lines in common, the worst case
+// for the search
+func disjoint(a, b []string) bool {
+	seen := make(map[string]bool, len(a))
+	for _, s := range a {
+		seen[s] = true
+	}
+	for _, s := range b {
+		if seen[s] {
+			return false
+		}
+	}
+	return true
+}
+
+// middleSnake finds the middle diagonal run of a shortest edit path through
+// a[aLo:aHi] and b[bLo:bHi], returning its start x, y and end u, v.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, int, int) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+	delta := n - m
+	max := n + m + 1
+	if len(d.vf) < 2*max+2 {
+		d.vf = make([]int, 2*max+2)
+		d.vb = make([]int, 2*max+2)
+	}
+	vf, vb := d.vf, d.vb
+	vf[max+1], vb[max+1] = 0, 0
+
+	for D := 0; D <= (n+m+1)/2; D++ {
+		// forward paths from the top left
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && vf[max+k-1] < vf[max+k+1]) {
+				x = vf[max+k+1]
+			} else {
+				x = vf[max+k-1] + 1
+			}
+			y := x - k
+			sx, sy := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			vf[max+k] = x
+			// the reverse path on this diagonal, if any, has D-1 edits
+			if c := delta - k; delta%2 != 0 && c >= -(D-1) && c <= D-1 && x+vb[max+c] >= n {
+				return aLo + sx, bLo + sy, aLo + x, bLo + y
+			}
+		}
+		// reverse paths from the bottom right
+		for c := -D; c <= D; c += 2 {
+			var x int
+			if c == -D || (c != D && vb[max+c-1] < vb[max+c+1]) {
+				x = vb[max+c+1]
+			} else {
+				x = vb[max+c-1] + 1
+			}
+			y := x - c
+			sx, sy := x, y
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			vb[max+c] = x
+			if k := delta - c; delta%2 == 0 && k >= -D && k <= D && x+vf[max+k] >= n {
+				return aLo + n - x, bLo + m - y, aLo + n - sx, bLo + m - sy
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// Unified returns a unified diff turning old into new, with three lines of
+// context, or "" if they are the same.
+func Unified(oldName, newName, old, new string) string {
+	if old == new {
+		return ""
+	}
+	edits := Lines(split(old), split(new))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	const context = 3
+	// line numbers in old and new before each edit
+	oldLine, newLine := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.Op != Insert {
+			oldLine[i+1]++
+		}
+		if e.Op != Delete {
+			newLine[i+1]++
+		}
+	}
+	for i := 0; i < len(edits); {
+		if edits[i].Op == Equal {
+			i++
+			continue
+		}
+		// extend the hunk while changes are within two contexts of each other
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].Op != Equal {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		stop := end + context
+		if stop > len(edits) {
+			stop = len(edits)
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", span(oldLine[start], oldLine[stop]), span(newLine[start], newLine[stop]))
+		for _, e := range edits[start:stop] {
+			b.WriteString([]string{" ", "-", "+"}[e.Op])
+			b.WriteString(e.Line)
+			b.WriteString("\n")
+		}
+		i = stop
+	}
+	return b.String()
+}
+
+// span formats the lines after first up to last as start,count
+func span(first, last int) string {
+	if last == first {
+		return fmt.Sprintf("%d,0", first)
+	}
+	if last-first == 1 {
+		return fmt.Sprintf("%d", first+1)
+	}
+	return fmt.Sprintf("%d,%d", first+1, last-first)
+}
+
+// split text into lines, without line endings
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
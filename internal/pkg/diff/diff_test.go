@@ -0,0 +1,98 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lcs returns the length of the longest common subsequence of a and b
+func lcs(a, b []string) int {
+	t := make([][]int, len(a)+1)
+	for i := range t {
+		t[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				t[i][j] = t[i+1][j+1] + 1
+			} else if t[i+1][j] > t[i][j+1] {
+				t[i][j] = t[i+1][j]
+			} else {
+				t[i][j] = t[i][j+1]
+			}
+		}
+	}
+	return t[0][0]
+}
+
+func TestLinesMinimal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		s := make([]string, r.Intn(12))
+		for i := range s {
+			s[i] = string(rune('a' + r.Intn(3)))
+		}
+		return s
+	}
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		edits := Lines(a, b)
+
+		var gotA, gotB []string
+		equal := 0
+		for _, e := range edits {
+			if e.Op != Insert {
+				gotA = append(gotA, e.Line)
+			}
+			if e.Op != Delete {
+				gotB = append(gotB, e.Line)
+			}
+			if e.Op == Equal {
+				equal++
+			}
+		}
+		assert.Equal(t, strings.Join(a, ""), strings.Join(gotA, ""))
+		assert.Equal(t, strings.Join(b, ""), strings.Join(gotB, ""))
+		assert.Equal(t, lcs(a, b), equal, "%v %v", a, b)
+	}
+}
+
+func TestUnified(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nm\nn\n"
+	new := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\nn\no\n"
+	assert.Equal(t, `--- x.orig
++++ x
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -10,5 +10,5 @@
+ j
+ k
+ l
+-m
+ n
++o
+`, Unified("x.orig", "x", old, new))
+
+	assert.Equal(t, "", Unified("x", "x", old, old))
+	assert.Equal(t, "--- x\n+++ y\n@@ -0,0 +1 @@\n+a\n", Unified("x", "y", "", "a\n"))
+}
+
+func BenchmarkLines(b *testing.B) {
+	var old, new []string
+	// re-indenting every instruction of a program with blank lines between
+	for i := 0; i < 2000; i++ {
+		old = append(old, "@"+string(rune('a'+i%26)), "")
+		new = append(new, "    @"+string(rune('a'+i%26)), "")
+	}
+	for i := 0; i < b.N; i++ {
+		Lines(old, new)
+	}
+}
@@ -0,0 +1,153 @@
+package disasm
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+)
+
+// Decode one machine word into a command.A or command.C.
+func Decode(word uint16) (command.Any, error) {
+	if word&0x8000 == 0 {
+		return command.A{Address: int(word), Static: true}, nil
+	}
+	if word&0xe000 != 0xe000 {
+		return nil, fmt.Errorf("not a C instruction, prefix must be 111: %016b", word)
+	}
+	bits := int(word>>6) & 0x7f
+	c, ok := assembler.CompMnemonic(bits)
+	if !ok {
+		// no mnemonic, give the control bits
+		c = fmt.Sprintf("%%%07b", bits)
+	}
+	j, _ := assembler.JumpMnemonic(int(word) & 0x7)
+	return command.C{
+		D: command.Dest{A: word&0x20 != 0, D: word&0x10 != 0, M: word&0x08 != 0},
+		C: c,
+		J: j,
+	}, nil
+}
+
+// Disassemble words into a program. If symbols is not nil, labels are
+// restored at their ROM addresses and A instructions loading a label or
+// variable are given its name.
+func Disassemble(words []uint16, symbols *assembler.SymbolTable) (command.Program, error) {
+	program := make(command.Program, len(words))
+	for i, w := range words {
+		c, err := Decode(w)
+		if err != nil {
+			return nil, fmt.Errorf("address %d: %v", i, err)
+		}
+		program[i] = c
+	}
+	if symbols == nil {
+		return program, nil
+	}
+
+	named := name(program, symbols, true)
+	if !same(named, words) {
+		// variables are allocated in order of first use, so naming only
+		// some loads of a variable can reorder them; labels are always safe
+		named = name(program, symbols, false)
+	}
+	return named, nil
+}
+
+// name applies symbols to a decoded program, inserting labels.
+func name(program command.Program, symbols *assembler.SymbolTable, variables bool) command.Program {
+	labels := map[int][]string{}
+	byValue := map[assembler.Kind]map[int]string{assembler.Label: {}, assembler.Variable: {}}
+	for _, s := range symbols.Symbols() {
+		if s.Kind == assembler.Label {
+			labels[s.Value] = append(labels[s.Value], s.Name)
+		}
+		if m, ok := byValue[s.Kind]; ok {
+			if _, ok := m[s.Value]; !ok {
+				m[s.Value] = s.Name
+			}
+		}
+	}
+
+	var result command.Program
+	for i, c := range program {
+		for _, l := range labels[i] {
+			result = append(result, command.L{Symbol: l})
+		}
+		if a, ok := c.(command.A); ok {
+			label, isLabel := byValue[assembler.Label][a.Address]
+			variable, isVar := byValue[assembler.Variable][a.Address]
+			if !variables {
+				isVar = false
+			}
+			if isLabel && isVar {
+				// guess from how the next instruction uses A
+				next, _ := at(program, i+1).(command.C)
+				if next.J != "" {
+					isVar = false
+				} else {
+					isLabel = false
+				}
+			}
+			if isLabel {
+				c = command.A{Symbol: label}
+			} else if isVar {
+				c = command.A{Symbol: variable}
+			}
+		}
+		result = append(result, c)
+	}
+	for _, l := range labels[len(program)] {
+		result = append(result, command.L{Symbol: l})
+	}
+	return result
+}
+
+// same returns true if program assembles to exactly words
+func same(program command.Program, words []uint16) bool {
+	text, _, err := assembler.Assemble(program, nil)
+	if err != nil || len(text) != len(words) {
+		return false
+	}
+	for i, s := range text {
+		if s != fmt.Sprintf("%016b", words[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Hex spells the addresses of static A instructions in hexadecimal.
+func Hex(program command.Program) {
+	for i, c := range program {
+		if a, ok := c.(command.A); ok && a.Static {
+			a.Text = fmt.Sprintf("0x%04X", a.Address)
+			program[i] = a
+		}
+	}
+}
+
+func at(program command.Program, i int) command.Any {
+	if i < len(program) {
+		return program[i]
+	}
+	return nil
+}
+
+// Write program as canonical Hack assembly, labels flush-left and other
+// commands indented.
+func Write(w io.Writer, program command.Program) error {
+	for _, c := range program {
+		var err error
+		if l, ok := c.(command.L); ok {
+			_, err = fmt.Fprintln(w, l)
+		} else {
+			_, err = fmt.Fprintf(w, "    %v\n", c)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package disasm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+)
+
+func TestDecode(t *testing.T) {
+	testCases := map[uint16]command.Any{
+		0b0000000000000111: command.A{Address: 7, Static: true},
+		0b1111110111011000: command.C{D: command.Dest{M: true, D: true}, C: "M+1"},
+		0b1110001100000001: command.C{C: "D", J: "JGT"},
+		0b1111000010111111: command.C{D: command.Dest{A: true, M: true, D: true}, C: "D+M", J: "JMP"},
+		// no mnemonic for these control bits
+		0b1111111111000000: command.C{C: "%1111111"},
+	}
+	for w, expected := range testCases {
+		c, err := Decode(w)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, c)
+	}
+
+	_, err := Decode(0b1000110000010000)
+	assert.Error(t, err)
+}
+
+func TestDisassembleWithSymbols(t *testing.T) {
+	symbols := &assembler.SymbolTable{}
+	symbols.Define(assembler.Symbol{Name: "LOOP", Kind: assembler.Label, Value: 2})
+	symbols.Define(assembler.Symbol{Name: "i", Kind: assembler.Variable, Value: 16})
+	symbols.Define(assembler.Symbol{Name: "END", Kind: assembler.Label, Value: 4})
+	symbols.Define(assembler.Symbol{Name: "TOP", Kind: assembler.Label, Value: 16})
+
+	words := []uint16{
+		16,                 // @i
+		0b1110111111001000, // M=1
+		2,                  // @LOOP
+		0b1110101010000111, // 0;JMP
+	}
+	program, err := Disassemble(words, symbols)
+	assert.NoError(t, err)
+
+	var b bytes.Buffer
+	assert.NoError(t, Write(&b, program))
+	assert.Equal(t, "    @i\n    M=1\n(LOOP)\n    @LOOP\n    0;JMP\n(END)\n", b.String())
+}
+
+func TestHex(t *testing.T) {
+	program, err := Disassemble([]uint16{0x4000, 0b1110101010000111}, nil)
+	assert.NoError(t, err)
+	Hex(program)
+	var b bytes.Buffer
+	assert.NoError(t, Write(&b, program))
+	assert.Equal(t, "    @0x4000\n    0;JMP\n", b.String())
+}
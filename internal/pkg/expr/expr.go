@@ -0,0 +1,371 @@
+// Package expr parses and evaluates the constant expressions of A
+// instruction operands, such as @SCREEN+32 or @(KBD-SCREEN)/2.
+//
+// Operands are numbers, see Literal, and symbols, combined with the C operators, from
+// tightest binding:
+//
+//	unary - ~
+//	* / %
+//	+ -
+//	<< >>
+//	&
+//	^
+//	|
+//
+// and parentheses.
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Operators are the characters which may appear in expressions but not in
+// symbols or numbers.
+const Operators = "+-*/%()&|^~<>"
+
+// Expr is a parsed expression.
+type Expr interface {
+	// String formats the expression without spaces or redundant parentheses.
+	String() string
+}
+
+// Num is a number, spelled Text if that isn't decimal.
+type Num struct {
+	Value int
+	Text  string
+}
+
+// Sym is a symbol, Offset bytes into the expression's source.
+type Sym struct {
+	Name   string
+	Offset int
+}
+
+// Unary is an operator applied to one operand, - or ~.
+type Unary struct {
+	Op string
+	X  Expr
+}
+
+// Binary is an operator applied to two operands.
+type Binary struct {
+	Op   string
+	X, Y Expr
+}
+
+// precedence of binary operators, higher binds tighter
+var precedence = map[string]int{
+	"*": 6, "/": 6, "%": 6,
+	"+": 5, "-": 5,
+	"<<": 4, ">>": 4,
+	"&": 3,
+	"^": 2,
+	"|": 1,
+}
+
+// unaryPrecedence binds tighter than any binary operator
+const unaryPrecedence = 7
+
+func (e Num) String() string {
+	if e.Text != "" {
+		return e.Text
+	}
+	return strconv.Itoa(e.Value)
+}
+
+func (e Sym) String() string { return e.Name }
+
+func (e Unary) String() string { return e.Op + operand(e.X, unaryPrecedence, false) }
+
+func (e Binary) String() string {
+	p := precedence[e.Op]
+	return operand(e.X, p, false) + e.Op + operand(e.Y, p, true)
+}
+
+// operand formats e as an operand of an operator of precedence p,
+// parenthesized if it binds looser, or as loose on the right, since all
+// binary operators are left associative.
+func operand(e Expr, p int, right bool) string {
+	if b, ok := e.(Binary); ok {
+		if q := precedence[b.Op]; q < p || (right && q == p) {
+			return "(" + b.String() + ")"
+		}
+	}
+	return e.String()
+}
+
+// Error is a problem with an expression, Offset bytes into its source.
+type Error struct {
+	Offset int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// UndefinedError is the error evaluating an expression with a symbol which
+// isn't found.
+type UndefinedError struct {
+	Sym Sym
+}
+
+func (e *UndefinedError) Error() string {
+	return "undefined symbol " + e.Sym.Name
+}
+
+// Parse an expression.
+func Parse(s string) (Expr, error) {
+	p := &parser{s: s}
+	p.next()
+	e, err := p.expr(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != "" {
+		return nil, p.errorf("unexpected %s in expression %s", p.tok, s)
+	}
+	return e, nil
+}
+
+// parser is a precedence climbing parser, holding the current token
+type parser struct {
+	s   string
+	pos int
+	// tok is the current token and off its offset, tok is empty at the end
+	tok string
+	off int
+}
+
+// next advances to the next token
+func (p *parser) next() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+	p.off = p.pos
+	if p.pos == len(p.s) {
+		p.tok = ""
+		return
+	}
+	switch {
+	case p.s[p.pos] == '\'':
+		// a character, which may be an operator or space
+		for p.pos++; p.pos < len(p.s) && p.s[p.pos] != '\''; p.pos++ {
+			if p.s[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos < len(p.s) {
+			p.pos++
+		}
+		if p.pos > len(p.s) {
+			p.pos = len(p.s)
+		}
+	case strings.HasPrefix(p.s[p.pos:], "<<"), strings.HasPrefix(p.s[p.pos:], ">>"):
+		p.pos += 2
+	case strings.IndexByte(Operators, p.s[p.pos]) != -1:
+		p.pos++
+	default:
+		for p.pos < len(p.s) && strings.IndexByte(Operators+" \t", p.s[p.pos]) == -1 {
+			p.pos++
+		}
+	}
+	p.tok = p.s[p.off:p.pos]
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &Error{Offset: p.off, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expr parses binary operations of at least precedence min
+func (p *parser) expr(min int) (Expr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		prec, ok := precedence[p.tok]
+		if !ok || prec < min {
+			return x, nil
+		}
+		op := p.tok
+		p.next()
+		y, err := p.expr(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		x = Binary{Op: op, X: x, Y: y}
+	}
+}
+
+// unary parses an operand, optionally negated or complemented
+func (p *parser) unary() (Expr, error) {
+	switch tok := p.tok; {
+	case tok == "-" || tok == "~":
+		p.next()
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return Unary{Op: tok, X: x}, nil
+	case tok == "(":
+		p.next()
+		x, err := p.expr(1)
+		if err != nil {
+			return nil, err
+		}
+		if p.tok != ")" {
+			return nil, p.errorf("missing ) in expression %s", p.s)
+		}
+		p.next()
+		return x, nil
+	case tok == "":
+		return nil, p.errorf("missing operand in expression %s", p.s)
+	case strings.Contains(Operators, tok):
+		return nil, p.errorf("unexpected %s in expression %s", tok, p.s)
+	case IsLiteral(tok):
+		n, err := Literal(tok)
+		if isRange(err) {
+			return nil, p.errorf("number %s too large in expression %s", tok, p.s)
+		}
+		if err != nil {
+			return nil, p.errorf("%v in expression %s", err, p.s)
+		}
+		x := Num{Value: n}
+		if !isDecimal(tok) {
+			x.Text = tok
+		}
+		p.next()
+		return x, nil
+	default:
+		x := Sym{Name: tok, Offset: p.off}
+		p.next()
+		return x, nil
+	}
+}
+
+// Eval evaluates e, looking up the value of symbols. Symbols which aren't
+// found, and division by zero, are errors.
+func Eval(e Expr, lookup func(name string) (int, bool)) (int, error) {
+	switch e := e.(type) {
+	case Num:
+		return e.Value, nil
+	case Sym:
+		v, ok := lookup(e.Name)
+		if !ok {
+			return 0, &UndefinedError{Sym: e}
+		}
+		return v, nil
+	case Unary:
+		x, err := Eval(e.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		if e.Op == "~" {
+			return ^x, nil
+		}
+		return -x, nil
+	case Binary:
+		x, err := Eval(e.X, lookup)
+		if err != nil {
+			return 0, err
+		}
+		y, err := Eval(e.Y, lookup)
+		if err != nil {
+			return 0, err
+		}
+		return binary(e.Op, x, y)
+	}
+	return 0, fmt.Errorf("unknown expression %T", e)
+}
+
+// binary applies a binary operator
+func binary(op string, x, y int) (int, error) {
+	switch op {
+	case "*":
+		return x * y, nil
+	case "/", "%":
+		if y == 0 {
+			return 0, fmt.Errorf("division by zero")
+		}
+		if op == "/" {
+			return x / y, nil
+		}
+		return x % y, nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "<<", ">>":
+		if y < 0 || y > 15 {
+			return 0, fmt.Errorf("shift by %d, must be 0..15", y)
+		}
+		if op == "<<" {
+			return x << uint(y), nil
+		}
+		return x >> uint(y), nil
+	case "&":
+		return x & y, nil
+	case "^":
+		return x ^ y, nil
+	case "|":
+		return x | y, nil
+	}
+	return 0, fmt.Errorf("unknown operator %s", op)
+}
+
+// Fold replaces the symbols lookup finds by their values, and evaluates the
+// operations on numbers alone, returning an expression of the remaining
+// symbols.
+func Fold(e Expr, lookup func(name string) (int, bool)) (Expr, error) {
+	switch e := e.(type) {
+	case Sym:
+		if v, ok := lookup(e.Name); ok {
+			return Num{Value: v}, nil
+		}
+	case Unary:
+		x, err := Fold(e.X, lookup)
+		if err != nil {
+			return nil, err
+		}
+		e.X = x
+		if _, ok := x.(Num); ok {
+			v, err := Eval(e, lookup)
+			return Num{Value: v}, err
+		}
+		return e, nil
+	case Binary:
+		x, err := Fold(e.X, lookup)
+		if err != nil {
+			return nil, err
+		}
+		y, err := Fold(e.Y, lookup)
+		if err != nil {
+			return nil, err
+		}
+		e.X, e.Y = x, y
+		_, xnum := x.(Num)
+		_, ynum := y.(Num)
+		if xnum && ynum {
+			v, err := Eval(e, lookup)
+			return Num{Value: v}, err
+		}
+		return e, nil
+	}
+	return e, nil
+}
+
+// Symbols returns the symbols of e, left to right.
+func Symbols(e Expr) []Sym {
+	switch e := e.(type) {
+	case Sym:
+		return []Sym{e}
+	case Unary:
+		return Symbols(e.X)
+	case Binary:
+		return append(Symbols(e.X), Symbols(e.Y)...)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+package expr
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// values of symbols for tests
+func lookup(name string) (int, bool) {
+	v, ok := map[string]int{"SCREEN": 16384, "KBD": 24576, "ROW": 2, "COL": 3, "END": 10}[name]
+	return v, ok
+}
+
+func TestEval(t *testing.T) {
+	testCases := map[string]int{
+		"SCREEN+32":       16416,
+		"ROW*32+COL":      67,
+		"END-1":           9,
+		"(KBD-SCREEN)/2":  4096,
+		" ( 1 + 2 ) * 3 ": 9,
+		"10-4-3":          3,
+		"10-(4-3)":        9,
+		"-1+2":            1,
+		"~0&32767":        32767,
+		"1<<4|1":          17,
+		"6^3&1":           7,
+		"1+2<<3":          24,
+		"7%4":             3,
+		"--5":             5,
+		"0x4000|0b1010":   16394,
+		"'A'+1":           66,
+		"' '+'+'":         75,
+		`'\''`:            39,
+	}
+	for src, expected := range testCases {
+		e, err := Parse(src)
+		assert.NoError(t, err, src)
+		v, err := Eval(e, lookup)
+		assert.NoError(t, err, src)
+		assert.Equal(t, expected, v, src)
+	}
+}
+
+func TestString(t *testing.T) {
+	testCases := map[string]string{
+		" SCREEN + 32 ":  "SCREEN+32",
+		"(ROW*32)+COL":   "ROW*32+COL",
+		"(KBD-SCREEN)/2": "(KBD-SCREEN)/2",
+		"10-(4-3)":       "10-(4-3)",
+		"(10-4)-3":       "10-4-3",
+		"-(ROW+1)":       "-(ROW+1)",
+		"~(1)":           "~1",
+		"(1|2)&3":        "(1|2)&3",
+		"SCREEN + 0x20":  "SCREEN+0x20",
+		"'A' + 1":        "'A'+1",
+	}
+	for src, expected := range testCases {
+		e, err := Parse(src)
+		assert.NoError(t, err, src)
+		assert.Equal(t, expected, e.String(), src)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := map[string]*Error{
+		"(1":                    {Offset: 2, Msg: "missing ) in expression (1"},
+		"1+":                    {Offset: 2, Msg: "missing operand in expression 1+"},
+		"1+*2":                  {Offset: 2, Msg: "unexpected * in expression 1+*2"},
+		"1)":                    {Offset: 1, Msg: "unexpected ) in expression 1)"},
+		"a b":                   {Offset: 2, Msg: "unexpected b in expression a b"},
+		"1x+2":                  {Offset: 0, Msg: "bad number 1x in expression 1x+2"},
+		"1+'ab'":                {Offset: 2, Msg: "bad character 'ab', expected a printable ASCII character or escape in expression 1+'ab'"},
+		"0x1FFFFFFFFFFFFFFFF+1": {Offset: 0, Msg: "number 0x1FFFFFFFFFFFFFFFF too large in expression 0x1FFFFFFFFFFFFFFFF+1"},
+	}
+	for src, expected := range testCases {
+		_, err := Parse(src)
+		assert.Equal(t, expected, err, src)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	testCases := map[string]string{
+		"SCREEN+x": "undefined symbol x",
+		"1/(2-2)":  "division by zero",
+		"1%0":      "division by zero",
+		"1<<16":    "shift by 16, must be 0..15",
+	}
+	for src, expected := range testCases {
+		e, err := Parse(src)
+		assert.NoError(t, err, src)
+		_, err = Eval(e, lookup)
+		assert.EqualError(t, err, expected, src)
+	}
+
+	e, _ := Parse("1 + x")
+	_, err := Eval(e, lookup)
+	assert.Equal(t, &UndefinedError{Sym: Sym{Name: "x", Offset: 4}}, err)
+}
+
+func TestFold(t *testing.T) {
+	e, err := Parse("LOOP+ROW*32+(COL-1)")
+	assert.NoError(t, err)
+	folded, err := Fold(e, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, "LOOP+64+2", folded.String())
+	assert.Equal(t, []Sym{{Name: "LOOP", Offset: 0}}, Symbols(folded))
+
+	folded, err = Fold(Unary{Op: "-", X: Sym{Name: "ROW"}}, lookup)
+	assert.NoError(t, err)
+	assert.Equal(t, Num{Value: -2}, folded)
+}
+
+func TestLiteral(t *testing.T) {
+	testCases := map[string]int{
+		"0":       0,
+		"32767":   32767,
+		"0x4000":  16384,
+		"0XfF":    255,
+		"0b1010":  10,
+		"0B1":     1,
+		"'A'":     65,
+		"' '":     32,
+		"'~'":     126,
+		`'\n'`:    128,
+		`'\b'`:    129,
+		`'\left'`: 130,
+		`'\e'`:    140,
+		`'\f12'`:  152,
+		`'\\'`:    92,
+		`'\''`:    39,
+	}
+	for src, expected := range testCases {
+		v, err := Literal(src)
+		assert.NoError(t, err, src)
+		assert.Equal(t, expected, v, src)
+	}
+
+	errorCases := map[string]string{
+		"0x":    "bad number 0x",
+		"0xZZ":  "bad number 0xZZ",
+		"0b102": "bad number 0b102",
+		"0x-1":  "bad number 0x-1",
+		"0x_1":  "bad number 0x_1",
+		"1abc":  "bad number 1abc",
+		"'ab'":  "bad character 'ab', expected a printable ASCII character or escape",
+		"'''":   "bad character ''', expected a printable ASCII character or escape",
+		"'A":    "bad character 'A, expected 'c'",
+		`'\q'`:  `unknown escape \q in character '\q'`,
+		"'\t'":  "bad character '\t', expected a printable ASCII character or escape",
+	}
+	for src, expected := range errorCases {
+		_, err := Literal(src)
+		assert.EqualError(t, err, expected, src)
+	}
+
+	_, err := Literal("0x10000000000000000")
+	assert.True(t, errors.Is(err, strconv.ErrRange))
+}
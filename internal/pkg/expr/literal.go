@@ -0,0 +1,109 @@
+package expr
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keys are the escapes of character literals for keys without a printable
+// ASCII character, giving their Hack keyboard codes, '\n' for newline and
+// so on.
+var Keys = map[string]int{
+	`\n`:     128,
+	`\b`:     129,
+	`\left`:  130,
+	`\up`:    131,
+	`\right`: 132,
+	`\down`:  133,
+	`\home`:  134,
+	`\end`:   135,
+	`\pgup`:  136,
+	`\pgdn`:  137,
+	`\ins`:   138,
+	`\del`:   139,
+	`\e`:     140,
+	`\f1`:    141,
+	`\f2`:    142,
+	`\f3`:    143,
+	`\f4`:    144,
+	`\f5`:    145,
+	`\f6`:    146,
+	`\f7`:    147,
+	`\f8`:    148,
+	`\f9`:    149,
+	`\f10`:   150,
+	`\f11`:   151,
+	`\f12`:   152,
+	`\\`:     '\\',
+	`\'`:     '\'',
+}
+
+// Literal parses a number: decimal, hexadecimal 0x4000, binary 0b1010, or a
+// character 'A' giving its Hack keyboard code, see Keys. Numbers too large
+// for an int return an error wrapping strconv.ErrRange.
+func Literal(s string) (int, error) {
+	if isDecimal(s) {
+		return strconv.Atoi(s)
+	}
+	if s != "" && s[0] == '\'' {
+		return char(s)
+	}
+	base := 0
+	switch {
+	case len(s) > 2 && (s[:2] == "0x" || s[:2] == "0X"):
+		base = 16
+	case len(s) > 2 && (s[:2] == "0b" || s[:2] == "0B"):
+		base = 2
+	default:
+		return 0, fmt.Errorf("bad number %s", s)
+	}
+	if strings.IndexAny(s[2:], "+-_") != -1 {
+		return 0, fmt.Errorf("bad number %s", s)
+	}
+	n, err := strconv.ParseInt(s[2:], base, 0)
+	if err != nil && !isRange(err) {
+		return 0, fmt.Errorf("bad number %s", s)
+	}
+	return int(n), err
+}
+
+// char parses a character literal
+func char(s string) (int, error) {
+	if len(s) < 3 || s[len(s)-1] != '\'' {
+		return 0, fmt.Errorf("bad character %s, expected 'c'", s)
+	}
+	c := s[1 : len(s)-1]
+	if strings.HasPrefix(c, `\`) {
+		if code, ok := Keys[c]; ok {
+			return code, nil
+		}
+		return 0, fmt.Errorf("unknown escape %s in character %s", c, s)
+	}
+	if len(c) != 1 || c[0] < ' ' || c[0] > '~' || c[0] == '\'' {
+		return 0, fmt.Errorf("bad character %s, expected a printable ASCII character or escape", s)
+	}
+	return int(c[0]), nil
+}
+
+// isDecimal returns true if s is all decimal digits
+func isDecimal(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// isRange returns true if err is a strconv range error
+func isRange(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
+
+// IsLiteral returns true if s is spelled as a number literal, though it may
+// be malformed: it starts with a digit or a quote.
+func IsLiteral(s string) bool {
+	return s != "" && (s[0] >= '0' && s[0] <= '9' || s[0] == '\'')
+}
@@ -0,0 +1,150 @@
+// Package format prints Hack assembly in canonical layout.
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cst"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Indent for instructions, labels are flush-left.
+const Indent = "    "
+
+// line is one formatted source line
+type line struct {
+	code    string
+	comment string
+}
+
+// Source formats src, named name in diagnostics. Labels are flush-left,
+// instructions indented, C instructions spelled canonically (MD=D+A for
+// DM=A+D), trailing comments aligned within each run of commented lines, and
+// runs of blank lines reduced to one. Macro definitions and calls are kept
+// as written. Sources which don't parse are returned as a diag.List.
+func Source(name string, src []byte) ([]byte, error) {
+	f, err := cst.Parse(name, bytes.NewReader(src), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []line
+	add := func(l line) {
+		if blank(l) && (len(lines) == 0 || blank(lines[len(lines)-1])) {
+			return
+		}
+		lines = append(lines, l)
+	}
+	for _, s := range f.Statements {
+		for _, l := range commentLines(s.Leading()) {
+			add(l)
+		}
+		l := line{comment: strings.TrimRightFunc(s.Comment(), isSpace)}
+		if s.Verbatim() {
+			l.code = indentation(s.Leading()) + s.Text()
+		}
+		switch c := s.Command.(type) {
+		case command.L:
+			l.code = c.String()
+		case command.Directive:
+			l.code = c.String()
+		case command.Equ:
+			l.code = c.String()
+		case command.A:
+			l.code = Indent + c.String()
+		case command.C:
+			if canonical, ok := assembler.Canonical(c.C); ok {
+				c.C = canonical
+			}
+			l.code = Indent + c.String()
+		}
+		add(l)
+	}
+	for _, l := range commentLines(append(f.EOF.Leading, token.Trivia{Kind: token.Newline})) {
+		add(l)
+	}
+	for len(lines) > 0 && blank(lines[len(lines)-1]) {
+		lines = lines[:len(lines)-1]
+	}
+
+	var b bytes.Buffer
+	for i := 0; i < len(lines); {
+		// align trailing comments in a run of commented instructions
+		j, width := i, 0
+		for ; j < len(lines) && trailing(lines[j]); j++ {
+			if len(lines[j].code) > width {
+				width = len(lines[j].code)
+			}
+		}
+		if j == i {
+			l := lines[i]
+			b.WriteString(l.code)
+			b.WriteString(l.comment)
+			b.WriteByte('\n')
+			i++
+			continue
+		}
+		for ; i < j; i++ {
+			l := lines[i]
+			b.WriteString(l.code)
+			b.WriteString(strings.Repeat(" ", width-len(l.code)+1))
+			b.WriteString(l.comment)
+			b.WriteByte('\n')
+		}
+	}
+	return b.Bytes(), nil
+}
+
+// commentLines returns the comment and blank lines in trivia, ignoring any
+// text after the last newline, which is the indentation of a statement.
+func commentLines(trivia []token.Trivia) []line {
+	var result []line
+	var l line
+	indented := false
+	for _, t := range trivia {
+		switch t.Kind {
+		case token.Whitespace:
+			indented = true
+		case token.Comment:
+			l.comment = strings.TrimRightFunc(t.Text, isSpace)
+			if indented {
+				// keep indented comments with the instructions
+				l.code = Indent
+			}
+		case token.Newline:
+			result = append(result, l)
+			l, indented = line{}, false
+		}
+	}
+	return result
+}
+
+// indentation returns the whitespace in trivia after the last newline
+func indentation(trivia []token.Trivia) string {
+	result := ""
+	for _, t := range trivia {
+		switch t.Kind {
+		case token.Whitespace:
+			result = t.Text
+		case token.Newline:
+			result = ""
+		}
+	}
+	return result
+}
+
+func blank(l line) bool {
+	return l.code == "" && l.comment == ""
+}
+
+// trailing returns true if l has a comment after a command
+func trailing(l line) bool {
+	return strings.TrimSpace(l.code) != "" && l.comment != ""
+}
+
+func isSpace(r rune) bool {
+	return r == ' ' || r == '\t'
+}
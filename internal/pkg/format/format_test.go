@@ -0,0 +1,122 @@
+package format
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/preproc"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSource(t *testing.T) {
+	src := "\n\n// Adds R0 and R1\r\n@R0 // first\r\n\t\tD=M\n  @R1   // second operand\nDM=M+D // add\n\n\n\n   // store\n@R2\nM=D\n(END)    // halt\n@END\n0;JMP   \n\n\n"
+	expected := `// Adds R0 and R1
+    @R0 // first
+    D=M
+    @R1    // second operand
+    MD=D+M // add
+
+    // store
+    @R2
+    M=D
+(END) // halt
+    @END
+    0;JMP
+`
+	got, err := Source("add.asm", []byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(got))
+
+	// formatting is idempotent
+	again, err := Source("add.asm", got)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(again))
+}
+
+func TestSourceDirectives(t *testing.T) {
+	got, err := Source("m.asm", []byte("  .export  MAIN,LOOP // entry\n.import\tLIB\n  .define W,  32\n(MAIN)\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".export MAIN, LOOP // entry\n.import LIB\n.define W 32\n(MAIN)\n", string(got))
+}
+
+func TestSourceLiterals(t *testing.T) {
+	got, err := Source("k.asm", []byte(".equ NL '\\n'\n@0x4000\n@0b1010\n@ 'A' + 1\n@' '\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".equ NL '\\n'\n    @0x4000\n    @0b1010\n    @'A'+1\n    @' '\n", string(got))
+}
+
+func TestSourceMacros(t *testing.T) {
+	src := `.macro PUSH_CONST n   // push n
+  @n
+    D=A
+  @SP
+  AM=M+1
+  A=A-1
+  M=D
+.endm
+.macro PUSH2 a, b
+    PUSH_CONST a
+    PUSH_CONST  b
+.endm
+@256
+D=A
+@SP
+M=D
+  PUSH_CONST 7  // seven
+    PUSH2 1, 2
+`
+	expected := `.macro PUSH_CONST n // push n
+  @n
+    D=A
+  @SP
+  AM=M+1
+  A=A-1
+  M=D
+.endm
+.macro PUSH2 a, b
+    PUSH_CONST a
+    PUSH_CONST  b
+.endm
+    @256
+    D=A
+    @SP
+    M=D
+  PUSH_CONST 7 // seven
+    PUSH2 1, 2
+`
+	got, err := Source("m.asm", []byte(src))
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(got))
+
+	again, err := Source("m.asm", got)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, string(again))
+
+	// the formatted source assembles the same
+	assert.Equal(t, assemble(t, src), assemble(t, string(got)))
+
+	// macros may come from included files
+	got, err = Source("m.asm", []byte(".include \"stack.asm\"\n  PUSH_CONST 7\nD=M\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, ".include \"stack.asm\"\n  PUSH_CONST 7\n    D=M\n", string(got))
+}
+
+// assemble src, expanding macros
+func assemble(t *testing.T, src string) []string {
+	tokens, err := preproc.TokenizeFile("", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	words, _, err := assembler.Assemble(program, nil)
+	assert.NoError(t, err)
+	return words
+}
+
+func TestSourceErrors(t *testing.T) {
+	_, err := Source("bad.asm", []byte("@1\nD=M;JPM\nMM=D\n"))
+	assert.IsType(t, diag.List{}, err)
+	assert.EqualError(t, err, "bad.asm:2:5: error[E003]: unknown jump 'JPM' in: D=M;JPM (did you mean JMP?)\nbad.asm:3:2: error[E004]: duplicate destination M")
+}
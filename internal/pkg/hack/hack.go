@@ -0,0 +1,55 @@
+package hack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Read a .hack file of 16-bit binary words, one per line. Blank lines are
+// skipped.
+func Read(r io.Reader) ([]uint16, error) {
+	var words []uint16
+	scanner := bufio.NewScanner(r)
+	n := 0
+	for scanner.Scan() {
+		n++
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		w, err := Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		words = append(words, w)
+	}
+	return words, scanner.Err()
+}
+
+// Words parses assembler output into words.
+func Words(instructions []string) ([]uint16, error) {
+	words := make([]uint16, len(instructions))
+	for i, s := range instructions {
+		w, err := Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("instruction %d: %v", i, err)
+		}
+		words[i] = w
+	}
+	return words, nil
+}
+
+// Parse one 16 character binary word.
+func Parse(s string) (uint16, error) {
+	if len(s) != 16 {
+		return 0, fmt.Errorf("expected 16 binary digits, got: %s", s)
+	}
+	v, err := strconv.ParseUint(s, 2, 16)
+	if err != nil {
+		return 0, fmt.Errorf("expected 16 binary digits, got: %s", s)
+	}
+	return uint16(v), nil
+}
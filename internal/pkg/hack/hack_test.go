@@ -0,0 +1,25 @@
+package hack
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRead(t *testing.T) {
+	words, err := Read(strings.NewReader("0000000000000111\r\n\n1110101010000111\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{7, 0xea87}, words)
+
+	_, err = Read(strings.NewReader("0000000000000111\n111\n"))
+	assert.EqualError(t, err, "line 2: expected 16 binary digits, got: 111")
+	_, err = Read(strings.NewReader("000000000000011x\n"))
+	assert.Error(t, err)
+}
+
+func TestWords(t *testing.T) {
+	words, err := Words([]string{"0000000000000001", "1111111111111111"})
+	assert.NoError(t, err)
+	assert.Equal(t, []uint16{1, 0xffff}, words)
+}
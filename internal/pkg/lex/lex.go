@@ -2,82 +2,317 @@ package lex
 
 import (
 	"bufio"
-	"fmt"
 	"io"
 	"strings"
+	"unicode"
 
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
-// TODO encapsulate
-var (
-	globalTokens = make([]token.Token, 0, 20)
-	lexCTokens   = make([]token.Token, 0, 20)
-)
+// jumps are the valid jump mnemonics
+var jumps = []string{"JEQ", "JGE", "JGT", "JLE", "JLT", "JMP", "JNE"}
+
+// Lexer tokenizes nand2tetris assembly. It owns the token buffers re-used
+// between lines, so a Lexer must not be shared between goroutines; separate
+// Lexers are safe to use concurrently.
+type Lexer struct {
+	// Trivia makes the lexer lossless: whitespace, comments, line endings and
+	// lines which fail to tokenize are kept as token trivia, and RPAREN,
+	// SEMICOLON and EOF tokens are added so every source byte belongs to a
+	// token. Macro definitions and calls, which are expanded before lexing
+	// when assembling, are kept whole as MACRO statements. Lossless streams
+	// are parsed by package cst.
+	Trivia bool
+
+	tokens  []token.Token
+	cTokens []token.Token
+}
+
+// New returns a Lexer with buffers sized for typical statements.
+func New() *Lexer {
+	return &Lexer{
+		tokens:  make([]token.Token, 0, 20),
+		cTokens: make([]token.Token, 0, 20),
+	}
+}
 
 // Tokenize
 func Tokenize(r io.Reader) ([]token.Token, error) {
+	return New().Tokenize("", r, nil)
+}
+
+// TokenizeFile tokenizes r with a new Lexer, see Lexer.Tokenize.
+func TokenizeFile(name string, r io.Reader, diags *diag.List) ([]token.Token, error) {
+	return New().Tokenize(name, r, diags)
+}
+
+// Tokenize r, recording name as the file of each token position.
+// Lines which fail to tokenize are reported to diags and skipped, the returned
+// error is non-nil if diags holds any errors.
+func (l *Lexer) Tokenize(name string, r io.Reader, diags *diag.List) ([]token.Token, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	if l.Trivia {
+		return l.tokenizeTrivia(name, r, diags)
+	}
 	var result []token.Token
 
 	scanner := bufio.NewScanner(r)
+	pos := token.Pos{File: name, Col: 1}
 	for scanner.Scan() {
-		line := scanner.Text()
-		tokens, err := tokenize(line)
+		pos.Line++
+		tokens, err := l.tokenize(scanner.Text(), pos)
 		if err != nil {
-			return []token.Token{}, fmt.Errorf("failed to tokenize line '%s': %v", line, err)
+			diags.Add(err)
+			continue
 		}
 		result = append(result, tokens...)
 	}
-	return result, nil
+	if err := scanner.Err(); err != nil {
+		return result, err
+	}
+	return result, diags.Err()
+}
+
+// TokenizeLine tokenizes one line of source starting at pos, for callers
+// which read lines themselves, such as the macro preprocessor. The result
+// is only valid until the next call.
+func (l *Lexer) TokenizeLine(line string, pos token.Pos) ([]token.Token, error) {
+	return l.tokenize(line, pos)
+}
+
+// tokenizeTrivia is Tokenize for lossless mode. Trivia before a statement,
+// including whole comment, blank and skipped lines, leads its first token, and
+// trivia after it up to the end of the line trails its END token.
+func (l *Lexer) tokenizeTrivia(name string, r io.Reader, diags *diag.List) ([]token.Token, error) {
+	var result []token.Token
+	var pending []token.Trivia
+	m := macros{names: map[string]bool{}}
+
+	br := bufio.NewReader(r)
+	pos := token.Pos{File: name, Col: 1}
+	for {
+		raw, err := br.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return result, err
+		}
+		if raw == "" {
+			break
+		}
+		pos.Line++
+		line, newline := splitNewline(raw)
+		var tokens []token.Token
+		var lerr error
+		if m.verbatim(clean(line)) {
+			tokens = lexMacro(line, pos)
+		} else {
+			tokens, lerr = l.tokenize(line, pos)
+		}
+		switch {
+		case lerr != nil:
+			diags.Add(lerr)
+			pending = appendTrivia(pending, token.Skipped, line)
+			pending = appendTrivia(pending, token.Newline, newline)
+		case len(tokens) == 0:
+			i := strings.Index(line, "//")
+			if i == -1 {
+				i = len(line)
+			}
+			pending = appendTrivia(pending, token.Whitespace, line[:i])
+			pending = appendTrivia(pending, token.Comment, line[i:])
+			pending = appendTrivia(pending, token.Newline, newline)
+		default:
+			start := len(result)
+			result = appendPunctuation(result, tokens)
+			first := &result[start]
+			first.Leading = appendTrivia(pending, token.Whitespace, line[:indent(line)])
+			pending = nil
+
+			// after the statement, whitespace then an optional comment
+			rest := line[indent(line)+len(clean(line)):]
+			i := strings.Index(rest, "//")
+			if i == -1 {
+				i = len(rest)
+			}
+			last := &result[len(result)-1]
+			last.Trailing = appendTrivia(nil, token.Whitespace, rest[:i])
+			last.Trailing = appendTrivia(last.Trailing, token.Comment, rest[i:])
+			last.Trailing = appendTrivia(last.Trailing, token.Newline, newline)
+		}
+		if err == io.EOF {
+			break
+		}
+	}
+	pos.Line++
+	result = append(result, token.Token{Type: token.EOF, Pos: pos, Leading: pending})
+	return result, diags.Err()
+}
+
+// macros tracks the macro definitions of a lossless stream
+type macros struct {
+	names map[string]bool
+	// body is true between .macro and .endm
+	body bool
+	// include is true after an .include, which may define macros
+	include bool
+}
+
+// verbatim returns true if statement s is part of a macro definition or a
+// macro call, so it is kept as written. After an .include, any statement
+// which starts with a symbol and isn't a C instruction is taken as a call of
+// an included macro.
+func (m *macros) verbatim(s string) bool {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return false
+	}
+	switch {
+	case m.body:
+		m.body = fields[0] != ".endm"
+		return true
+	case fields[0] == ".macro":
+		if len(fields) > 1 {
+			m.names[fields[1]] = true
+		}
+		m.body = true
+		return true
+	case fields[0] == ".endm":
+		return true
+	case fields[0] == ".include":
+		m.include = true
+		return false
+	}
+	return m.names[fields[0]] || m.include && s[0] != '.' && !isC(s) && isSymbol(fields[0])
+}
+
+// lexMacro returns the MACRO statement of line
+func lexMacro(line string, pos token.Pos) []token.Token {
+	s := clean(line)
+	pos.Col += indent(line)
+	return []token.Token{{Value: s, Type: token.MACRO, Pos: pos}, end(s, pos)}
+}
+
+// isSymbol returns true if s is a valid symbol name
+func isSymbol(s string) bool {
+	if s == "" || s[0] >= '0' && s[0] <= '9' {
+		return false
+	}
+	for _, ch := range s {
+		if !unicode.IsLetter(ch) && !unicode.IsDigit(ch) && !strings.ContainsRune("_.$:", ch) {
+			return false
+		}
+	}
+	return true
+}
+
+// appendPunctuation copies a statement's tokens, adding the ) of a label and
+// the ; before a jump.
+func appendPunctuation(result []token.Token, tokens []token.Token) []token.Token {
+	for i, t := range tokens {
+		switch {
+		case t.Type == token.JUMP:
+			result = append(result, token.Token{Value: ";", Type: token.SEMICOLON, Pos: at(t.Pos, -1)})
+		case t.Type == token.END && tokens[0].Type == token.LABEL:
+			result = append(result, token.Token{Value: ")", Type: token.RPAREN, Pos: at(t.Pos, -1)})
+		}
+		result = append(result, tokens[i])
+	}
+	return result
+}
+
+// appendTrivia appends text as trivia of kind k, unless empty
+func appendTrivia(trivia []token.Trivia, k token.TriviaKind, text string) []token.Trivia {
+	if text == "" {
+		return trivia
+	}
+	return append(trivia, token.Trivia{Kind: k, Text: text})
+}
+
+// splitNewline splits the line ending from a line
+func splitNewline(s string) (string, string) {
+	if strings.HasSuffix(s, "\r\n") {
+		return s[:len(s)-2], "\r\n"
+	}
+	if strings.HasSuffix(s, "\n") {
+		return s[:len(s)-1], "\n"
+	}
+	return s, ""
 }
 
-// tokenize one line of nand2tetris assembly statement
-func tokenize(s string) ([]token.Token, error) {
-	s = clean(s)
+// tokenize one line of nand2tetris assembly statement, starting at pos.
+// The result aliases the Lexer's buffer and is only valid until the next call.
+func (l *Lexer) tokenize(line string, pos token.Pos) ([]token.Token, error) {
+	s := clean(line)
 	if s == "" {
 		return nil, nil
 	}
+	pos.Col += indent(line)
 
 	// convert string to a sequence of tokens
-	// zero out globalTokens slice, re-using memory space
-	globalTokens = globalTokens[:0]
+	// zero out tokens slice, re-using memory space
+	l.tokens = l.tokens[:0]
 	var tokens []token.Token
 	var err error
 	switch {
 	case s[0] == '@':
-		tokens, err = lexA(s)
+		tokens, err = lexA(s, pos)
 	case s[0] == '(':
-		tokens, err = lexL(s)
+		tokens, err = lexL(s, pos)
+	case s[0] == '.':
+		tokens, err = lexDirective(s, pos)
 	case isC(s):
-		tokens, err = lexC(s)
+		tokens, err = l.lexC(s, pos)
 	default:
-		return []token.Token{}, fmt.Errorf("unrecognized symbol: %s", s)
+		return []token.Token{}, diag.Errorf(pos, diag.Syntax, "unrecognized symbol: %s", s)
 	}
 	if err != nil {
-		return []token.Token{}, fmt.Errorf("error lexing '%s': %v", s, err)
+		return []token.Token{}, err
 	}
-	globalTokens = append(globalTokens, tokens...)
-	return globalTokens, nil
+	l.tokens = append(l.tokens, tokens...)
+	return l.tokens, nil
 }
 
-func lexL(s string) ([]token.Token, error) {
+func lexL(s string, pos token.Pos) ([]token.Token, error) {
 	label := strings.Trim(s, "()")
 	if len(s)-2 != len(label) {
-		return []token.Token{}, fmt.Errorf("malformed label: %v", s)
+		return []token.Token{}, diag.Errorf(pos, diag.Syntax, "malformed label: %v", s)
+	}
+	tokens := []token.Token{
+		{Value: "(", Type: token.LABEL, Pos: pos},
+		{Value: label, Type: token.SYMBOL, Pos: at(pos, 1)},
+		end(s, pos),
+	}
+	return tokens, nil
+}
+
+func lexDirective(s string, pos token.Pos) ([]token.Token, error) {
+	name := strings.TrimLeftFunc(s[1:], unicode.IsLetter)
+	if len(name) == len(s)-1 || (name != "" && !unicode.IsSpace(rune(name[0]))) {
+		return []token.Token{}, diag.Errorf(pos, diag.Syntax, "malformed directive: %s", s)
+	}
+	tokens := []token.Token{
+		{Value: s, Type: token.DIRECTIVE, Pos: pos},
+		end(s, pos),
 	}
-	tokens := []token.Token{{Value: "(", Type: token.LABEL}, token.Token{Value: label, Type: token.SYMBOL}, token.End}
 	return tokens, nil
 }
 
-func lexA(s string) ([]token.Token, error) {
+func lexA(s string, pos token.Pos) ([]token.Token, error) {
 	if len(s) < 2 {
-		return []token.Token{}, fmt.Errorf("malformed '@' command, too short: %s", s)
+		return []token.Token{}, diag.Errorf(pos, diag.Syntax, "malformed '@' command, too short: %s", s)
 	}
 	v := s[1:]
-	tokens := []token.Token{{Value: "@", Type: token.AT}, token.Token{Value: v, Type: typeFromVal(v)}, token.End}
+	tokens := []token.Token{
+		{Value: "@", Type: token.AT, Pos: pos},
+		{Value: v, Type: typeFromVal(v), Pos: at(pos, 1)},
+		end(s, pos),
+	}
 	return tokens, nil
 }
-func lexC(s string) ([]token.Token, error) {
+func (l *Lexer) lexC(s string, pos token.Pos) ([]token.Token, error) {
 	split := strings.IndexRune(s, ';')
 
 	// optimization - we know slice size needed based on if a jump field exists,
@@ -94,53 +329,119 @@ func lexC(s string) ([]token.Token, error) {
 		size = len(comp) + 1
 	}
 
-	lexCTokens = lexCTokens[:size]
+	eq := strings.IndexByte(comp, '=')
+	if p := strings.IndexByte(comp, '('); p != -1 && eq > p {
+		// the = is inside alu(...)
+		eq = -1
+	}
+	if eq != -1 {
+		for i, ch := range comp[:eq] {
+			if ch != 'A' && ch != 'D' && ch != 'M' {
+				return []token.Token{}, diag.Errorf(at(pos, i), diag.BadDest, "unknown destination '%c' in: %s", ch, s)
+			}
+		}
+	}
+	if raw := comp[eq+1:]; strings.HasPrefix(raw, "%") || strings.HasPrefix(raw, "alu(") {
+		return lexRawC(s, eq, split, pos)
+	}
+
+	if cap(l.cTokens) < size {
+		l.cTokens = make([]token.Token, size)
+	}
+	lexCTokens := l.cTokens[:size]
 	for i, ch := range comp {
 		switch ch {
 		case '=':
-			lexCTokens[i] = token.Token{Value: "=", Type: token.ASSIGN}
+			lexCTokens[i] = token.Token{Value: "=", Type: token.ASSIGN, Pos: at(pos, i)}
 		case '0':
-			lexCTokens[i] = token.Token{Value: "0", Type: token.NUMBER}
+			lexCTokens[i] = token.Token{Value: "0", Type: token.NUMBER, Pos: at(pos, i)}
 		case '1':
-			lexCTokens[i] = token.Token{Value: "1", Type: token.NUMBER}
+			lexCTokens[i] = token.Token{Value: "1", Type: token.NUMBER, Pos: at(pos, i)}
 		case '+':
-			lexCTokens[i] = token.Token{Value: "+", Type: token.OPERATOR}
+			lexCTokens[i] = token.Token{Value: "+", Type: token.OPERATOR, Pos: at(pos, i)}
 		case '-':
-			lexCTokens[i] = token.Token{Value: "-", Type: token.OPERATOR}
+			lexCTokens[i] = token.Token{Value: "-", Type: token.OPERATOR, Pos: at(pos, i)}
 		case '!':
-			lexCTokens[i] = token.Token{Value: "!", Type: token.OPERATOR}
+			lexCTokens[i] = token.Token{Value: "!", Type: token.OPERATOR, Pos: at(pos, i)}
 		case '&':
-			lexCTokens[i] = token.Token{Value: "&", Type: token.OPERATOR}
+			lexCTokens[i] = token.Token{Value: "&", Type: token.OPERATOR, Pos: at(pos, i)}
 		case '|':
-			lexCTokens[i] = token.Token{Value: "|", Type: token.OPERATOR}
+			lexCTokens[i] = token.Token{Value: "|", Type: token.OPERATOR, Pos: at(pos, i)}
 		case 'D':
-			lexCTokens[i] = token.Token{Value: "D", Type: token.LOCATION}
+			lexCTokens[i] = token.Token{Value: "D", Type: token.LOCATION, Pos: at(pos, i)}
 		case 'M':
-			lexCTokens[i] = token.Token{Value: "M", Type: token.LOCATION}
+			lexCTokens[i] = token.Token{Value: "M", Type: token.LOCATION, Pos: at(pos, i)}
 		case 'A':
-			lexCTokens[i] = token.Token{Value: "A", Type: token.LOCATION}
+			lexCTokens[i] = token.Token{Value: "A", Type: token.LOCATION, Pos: at(pos, i)}
 		default:
-			return []token.Token{}, fmt.Errorf("unexpected rune '%v' in: %s", ch, s)
+			return []token.Token{}, diag.Errorf(at(pos, i), diag.Syntax, "unexpected rune '%c' in: %s", ch, s)
 		}
 	}
-	if len(jump) > 0 {
-		switch jump {
-		case
-			"JGT",
-			"JEQ",
-			"JGE",
-			"JLT",
-			"JNE",
-			"JLE",
-			"JMP":
-			lexCTokens[len(lexCTokens)-2] = token.Token{Value: jump, Type: token.JUMP}
+	if split != -1 {
+		if !isJump(jump) {
+			d := diag.Errorf(at(pos, split+1), diag.UnknownJump, "unknown jump '%s' in: %s", jump, s)
+			return []token.Token{}, d.Suggest(diag.Closest(jump, jumps))
 		}
+		lexCTokens[len(lexCTokens)-2] = token.Token{Value: jump, Type: token.JUMP, Pos: at(pos, split+1)}
 	}
 
-	lexCTokens[len(lexCTokens)-1] = token.End
+	lexCTokens[len(lexCTokens)-1] = end(s, pos)
 	return lexCTokens, nil
 }
 
+// lexRawC lexes a C command whose computation is given as ALU control bits,
+// with the = of any destination at eq and the ; of any jump at split.
+func lexRawC(s string, eq, split int, pos token.Pos) ([]token.Token, error) {
+	var tokens []token.Token
+	for i, ch := range s[:eq+1] {
+		if ch == '=' {
+			tokens = append(tokens, token.Token{Value: "=", Type: token.ASSIGN, Pos: at(pos, i)})
+		} else {
+			tokens = append(tokens, token.Token{Value: string(ch), Type: token.LOCATION, Pos: at(pos, i)})
+		}
+	}
+	stop := len(s)
+	if split != -1 {
+		stop = split
+	}
+	tokens = append(tokens, token.Token{Value: s[eq+1 : stop], Type: token.RAWCOMP, Pos: at(pos, eq+1)})
+	if split != -1 {
+		jump := s[split+1:]
+		if !isJump(jump) {
+			d := diag.Errorf(at(pos, split+1), diag.UnknownJump, "unknown jump '%s' in: %s", jump, s)
+			return []token.Token{}, d.Suggest(diag.Closest(jump, jumps))
+		}
+		tokens = append(tokens, token.Token{Value: jump, Type: token.JUMP, Pos: at(pos, split+1)})
+	}
+	return append(tokens, end(s, pos)), nil
+}
+
+// isJump returns true if s is a jump mnemonic
+func isJump(s string) bool {
+	for _, j := range jumps {
+		if s == j {
+			return true
+		}
+	}
+	return false
+}
+
+// at returns the position offset bytes into the statement starting at pos
+func at(pos token.Pos, offset int) token.Pos {
+	pos.Col += offset
+	return pos
+}
+
+// end returns the END token for statement s starting at pos
+func end(s string, pos token.Pos) token.Token {
+	return token.Token{Type: token.END, Pos: at(pos, len(s))}
+}
+
+// indent returns the number of leading whitespace bytes in s
+func indent(s string) int {
+	return len(s) - len(strings.TrimLeftFunc(s, unicode.IsSpace))
+}
+
 func clean(s string) string {
 	i := strings.Index(s, "//")
 	if i > -1 {
@@ -162,6 +463,17 @@ func typeFromVal(s string) token.Type {
 	if isNum(s) {
 		return token.ADDRESS
 	}
+	if expr.IsLiteral(s) {
+		// malformed numbers too, for the parser to report, unless they're
+		// part of an expression
+		if _, err := expr.Literal(s); err == nil || !strings.ContainsAny(s, expr.Operators) {
+			return token.ADDRESS
+		}
+		return token.EXPR
+	}
+	if strings.ContainsAny(s, expr.Operators) {
+		return token.EXPR
+	}
 	return token.SYMBOL
 }
 
@@ -1,6 +1,8 @@
 package lex
 
 import (
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -24,14 +26,14 @@ func TestClean(t *testing.T) {
 func TestTokenizeTypeL(t *testing.T) {
 	testCases := map[string][]token.Token{
 		"(foobar)": {
-			{Type: token.LABEL, Value: "("},
-			{Type: token.SYMBOL, Value: "foobar"},
-			{Type: token.END, Value: ""},
+			{Type: token.LABEL, Value: "(", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.SYMBOL, Value: "foobar", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 9}},
 		},
 	}
 
 	for k, v := range testCases {
-		actual, err := tokenize(k)
+		actual, err := New().tokenize(k, token.Pos{Line: 1, Col: 1})
 		assert.NoError(t, err)
 		assert.Equal(t, v, actual)
 	}
@@ -40,23 +42,35 @@ func TestTokenizeTypeL(t *testing.T) {
 func TestTokenizeTypeC(t *testing.T) {
 	testCases := map[string][]token.Token{
 		"D=M+1;JNE": {
-			{Type: token.LOCATION, Value: "D"},
-			{Type: token.ASSIGN, Value: "="},
-			{Type: token.LOCATION, Value: "M"},
-			{Type: token.OPERATOR, Value: "+"},
-			{Type: token.NUMBER, Value: "1"},
-			{Type: token.JUMP, Value: "JNE"},
-			{Type: token.END, Value: ""},
+			{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ASSIGN, Value: "=", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.LOCATION, Value: "M", Pos: token.Pos{Line: 1, Col: 3}},
+			{Type: token.OPERATOR, Value: "+", Pos: token.Pos{Line: 1, Col: 4}},
+			{Type: token.NUMBER, Value: "1", Pos: token.Pos{Line: 1, Col: 5}},
+			{Type: token.JUMP, Value: "JNE", Pos: token.Pos{Line: 1, Col: 7}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 10}},
 		},
 		"D;JGT": {
-			{Type: token.LOCATION, Value: "D"},
-			{Type: token.JUMP, Value: "JGT"},
-			{Type: token.END, Value: ""},
+			{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.JUMP, Value: "JGT", Pos: token.Pos{Line: 1, Col: 3}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 6}},
+		},
+		"D=%0001100;JNE": {
+			{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ASSIGN, Value: "=", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.RAWCOMP, Value: "%0001100", Pos: token.Pos{Line: 1, Col: 3}},
+			{Type: token.JUMP, Value: "JNE", Pos: token.Pos{Line: 1, Col: 12}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 15}},
+		},
+		"alu(zx=1,f=1);JMP": {
+			{Type: token.RAWCOMP, Value: "alu(zx=1,f=1)", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.JUMP, Value: "JMP", Pos: token.Pos{Line: 1, Col: 15}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 18}},
 		},
 	}
 
 	for k, v := range testCases {
-		actual, err := tokenize(k)
+		actual, err := New().tokenize(k, token.Pos{Line: 1, Col: 1})
 		assert.NoError(t, err)
 		assert.Equal(t, v, actual)
 	}
@@ -65,25 +79,190 @@ func TestTokenizeTypeC(t *testing.T) {
 func TestTokenizeTypeA(t *testing.T) {
 	testCases := map[string][]token.Token{
 		"@100": {
-			{Type: token.AT, Value: "@"},
-			{Type: token.ADDRESS, Value: "100"},
-			{Type: token.END, Value: ""},
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ADDRESS, Value: "100", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 5}},
 		},
 		"@i": {
-			{Type: token.AT, Value: "@"},
-			{Type: token.SYMBOL, Value: "i"},
-			{Type: token.END, Value: ""},
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.SYMBOL, Value: "i", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 3}},
 		},
 		"@foo": {
-			{Type: token.AT, Value: "@"},
-			{Type: token.SYMBOL, Value: "foo"},
-			{Type: token.END, Value: ""},
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.SYMBOL, Value: "foo", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 5}},
+		},
+		"@(KBD-SCREEN)/2": {
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.EXPR, Value: "(KBD-SCREEN)/2", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 16}},
+		},
+		"@0x4000": {
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ADDRESS, Value: "0x4000", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 8}},
+		},
+		"@'-'": {
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ADDRESS, Value: "'-'", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 5}},
+		},
+		"@'A'+1": {
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.EXPR, Value: "'A'+1", Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 7}},
 		},
 	}
 
 	for k, v := range testCases {
-		actual, err := tokenize(k)
+		actual, err := New().tokenize(k, token.Pos{Line: 1, Col: 1})
 		assert.NoError(t, err)
 		assert.Equal(t, v, actual)
 	}
 }
+
+func TestTokenizePositions(t *testing.T) {
+	tokens, err := TokenizeFile("prog.asm", strings.NewReader("// header\n\n\t@i // comment\n  (LOOP)\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []token.Token{
+		{Type: token.AT, Value: "@", Pos: token.Pos{File: "prog.asm", Line: 3, Col: 2}},
+		{Type: token.SYMBOL, Value: "i", Pos: token.Pos{File: "prog.asm", Line: 3, Col: 3}},
+		{Type: token.END, Value: "", Pos: token.Pos{File: "prog.asm", Line: 3, Col: 4}},
+		{Type: token.LABEL, Value: "(", Pos: token.Pos{File: "prog.asm", Line: 4, Col: 3}},
+		{Type: token.SYMBOL, Value: "LOOP", Pos: token.Pos{File: "prog.asm", Line: 4, Col: 4}},
+		{Type: token.END, Value: "", Pos: token.Pos{File: "prog.asm", Line: 4, Col: 9}},
+	}, tokens)
+}
+
+func TestTokenizeConcurrent(t *testing.T) {
+	sources := []string{
+		"@100\nD=A\n(LOOP)\n@LOOP\n0;JMP\n",
+		"AM=M-1\nD=D|A;JNE\n@SCREEN\nM=!M\n",
+		"(END)\n@i\nMD=D+1;JGT\n@END\n",
+	}
+	expected := make([][]token.Token, len(sources))
+	for i, src := range sources {
+		tokens, err := Tokenize(strings.NewReader(src))
+		assert.NoError(t, err)
+		expected[i] = tokens
+	}
+
+	var wg sync.WaitGroup
+	shared := New()
+	var mu sync.Mutex
+	for n := 0; n < 64; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			l := New()
+			for j := 0; j < 50; j++ {
+				i := (n + j) % len(sources)
+				tokens, err := Tokenize(strings.NewReader(sources[i]))
+				assert.NoError(t, err)
+				assert.Equal(t, expected[i], tokens)
+
+				tokens, err = l.Tokenize("", strings.NewReader(sources[i]), nil)
+				assert.NoError(t, err)
+				assert.Equal(t, expected[i], tokens)
+
+				// a shared Lexer is fine as long as calls don't overlap
+				mu.Lock()
+				tokens, err = shared.Tokenize("", strings.NewReader(sources[i]), nil)
+				mu.Unlock()
+				assert.NoError(t, err)
+				assert.Equal(t, expected[i], tokens)
+			}
+		}(n)
+	}
+	wg.Wait()
+}
+
+func TestTokenizeLongStatement(t *testing.T) {
+	var l Lexer
+	actual, err := l.Tokenize("", strings.NewReader("AMDAMDAMDAMDAMDAMDAMD=D+1;JMP\n"), nil)
+	assert.NoError(t, err)
+
+	// more tokens than the lexer's buffers start with
+	var expected []token.Token
+	for i, r := range "AMDAMDAMDAMDAMDAMDAMD" {
+		expected = append(expected, token.Token{Type: token.LOCATION, Value: string(r), Pos: token.Pos{Line: 1, Col: i + 1}})
+	}
+	expected = append(expected,
+		token.Token{Type: token.ASSIGN, Value: "=", Pos: token.Pos{Line: 1, Col: 22}},
+		token.Token{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 1, Col: 23}},
+		token.Token{Type: token.OPERATOR, Value: "+", Pos: token.Pos{Line: 1, Col: 24}},
+		token.Token{Type: token.NUMBER, Value: "1", Pos: token.Pos{Line: 1, Col: 25}},
+		token.Token{Type: token.JUMP, Value: "JMP", Pos: token.Pos{Line: 1, Col: 27}},
+		token.Token{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 30}},
+	)
+	assert.Equal(t, expected, actual)
+}
+
+func BenchmarkTokenizeLine(b *testing.B) {
+	l := New()
+	pos := token.Pos{Line: 1, Col: 1}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, _ = l.tokenize("  AM=M-1;JNE // comment", pos)
+	}
+}
+
+func TestTokenizeTrivia(t *testing.T) {
+	l := New()
+	l.Trivia = true
+	tokens, err := l.Tokenize("", strings.NewReader("// add\n  (X) // label\r\nD;JGT\n\n"), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []token.Token{
+		{Type: token.LABEL, Value: "(", Pos: token.Pos{Line: 2, Col: 3}, Leading: []token.Trivia{
+			{Kind: token.Comment, Text: "// add"},
+			{Kind: token.Newline, Text: "\n"},
+			{Kind: token.Whitespace, Text: "  "},
+		}},
+		{Type: token.SYMBOL, Value: "X", Pos: token.Pos{Line: 2, Col: 4}},
+		{Type: token.RPAREN, Value: ")", Pos: token.Pos{Line: 2, Col: 5}},
+		{Type: token.END, Value: "", Pos: token.Pos{Line: 2, Col: 6}, Trailing: []token.Trivia{
+			{Kind: token.Whitespace, Text: " "},
+			{Kind: token.Comment, Text: "// label"},
+			{Kind: token.Newline, Text: "\r\n"},
+		}},
+		{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 3, Col: 1}},
+		{Type: token.SEMICOLON, Value: ";", Pos: token.Pos{Line: 3, Col: 2}},
+		{Type: token.JUMP, Value: "JGT", Pos: token.Pos{Line: 3, Col: 3}},
+		{Type: token.END, Value: "", Pos: token.Pos{Line: 3, Col: 6}, Trailing: []token.Trivia{
+			{Kind: token.Newline, Text: "\n"},
+		}},
+		{Type: token.EOF, Pos: token.Pos{Line: 5, Col: 1}, Leading: []token.Trivia{
+			{Kind: token.Newline, Text: "\n"},
+		}},
+	}, tokens)
+}
+
+func TestTokenizeTriviaMacros(t *testing.T) {
+	l := New()
+	l.Trivia = true
+	tokens, err := l.Tokenize("", strings.NewReader(".macro INC x\n  M=M+1 x\n.endm\n  INC i\nINCR\n"), nil)
+	assert.EqualError(t, err, "5:1: error[E001]: unrecognized symbol: INCR")
+	var values []string
+	for _, t := range tokens {
+		if t.Type == token.MACRO {
+			values = append(values, t.Value)
+		}
+	}
+	assert.Equal(t, []string{".macro INC x", "M=M+1 x", ".endm", "INC i"}, values)
+	assert.Equal(t, token.Pos{Line: 4, Col: 3}, tokens[6].Pos)
+}
+
+func TestTokenizeDirective(t *testing.T) {
+	tokens, err := New().tokenize("  .export A, B // exported", token.Pos{Line: 1, Col: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []token.Token{
+		{Type: token.DIRECTIVE, Value: ".export A, B", Pos: token.Pos{Line: 1, Col: 3}},
+		{Type: token.END, Value: "", Pos: token.Pos{Line: 1, Col: 15}},
+	}, tokens)
+
+	for _, s := range []string{".", ".1", ".export,A"} {
+		_, err := New().tokenize(s, token.Pos{Line: 1, Col: 1})
+		assert.EqualError(t, err, "1:1: error[E001]: malformed directive: "+s)
+	}
+}
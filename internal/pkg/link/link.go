@@ -0,0 +1,155 @@
+// Package link combines separately compiled objects into one program.
+package link
+
+import (
+	"fmt"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// export is a label exported by a module, at its linked address
+type export struct {
+	module string
+	addr   int
+	pos    token.Pos
+}
+
+// Link objects into a program. Modules are placed in ROM in order, the first
+// starting at 0, and their variables allocated RAM in order from 16. Each
+// relocation is resolved to a label of its module, else to the export of an
+// import, else to the module's variable.
+//
+// The symbol table holds exported labels by name, and each module's private
+// labels and variables as module:name. Problems are reported to diags, the
+// returned error is non-nil if diags holds any errors.
+func Link(objects []*object.Object, diags *diag.List) ([]string, *assembler.SymbolTable, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	symbols := assembler.NewSymbolTable()
+
+	// pass one: place modules and collect exports
+	bases := make([]int, len(objects))
+	exports := map[string]export{}
+	base := 0
+	for i, o := range objects {
+		bases[i] = base
+		for _, l := range o.Labels {
+			if !l.Export {
+				continue
+			}
+			if prev, ok := exports[l.Name]; ok {
+				diags.Add(diag.Errorf(l.Pos, diag.Link, "label %s already exported by module %s at %v", l.Name, prev.module, prev.pos))
+				continue
+			}
+			pos := l.Pos
+			exports[l.Name] = export{module: o.Module, addr: base + l.Value, pos: pos}
+			symbols.Define(assembler.Symbol{Name: l.Name, Kind: assembler.Label, Value: base + l.Value, Pos: &pos})
+		}
+		base += len(o.Words)
+	}
+	if base > command.MaxAddress+1 {
+		diags.Add(diag.Errorf(token.Pos{}, diag.BadAddress, "program of %d words does not fit in ROM, max %d", base, command.MaxAddress+1))
+		return nil, symbols, diags.Err()
+	}
+
+	// pass two: allocate variables and patch relocations
+	var words []string
+	ram := assembler.VarStart
+	outOfRAM := false
+	for i, o := range objects {
+		local := map[string]int{}
+		for _, l := range o.Labels {
+			local[l.Name] = bases[i] + l.Value
+			if !l.Export {
+				pos := l.Pos
+				symbols.Define(assembler.Symbol{Name: o.Module + ":" + l.Name, Kind: assembler.Label, Value: bases[i] + l.Value, Pos: &pos})
+			}
+		}
+		imported := map[string]bool{}
+		for _, name := range o.Imports {
+			imported[name] = true
+		}
+		variables := map[string]int{}
+		unallocated := map[string]bool{}
+		for _, name := range o.Variables {
+			if ram >= assembler.VarEnd {
+				unallocated[name] = true
+				continue
+			}
+			variables[name] = ram
+			symbols.Define(assembler.Symbol{Name: o.Module + ":" + name, Kind: assembler.Variable, Value: ram})
+			ram++
+		}
+
+		// resolve a symbol of a relocation, reporting those not found
+		resolve := func(rel object.Relocation, name string) (int, bool) {
+			if addr, ok := local[name]; ok {
+				return addr, true
+			}
+			if imported[name] {
+				e, ok := exports[name]
+				if !ok {
+					diags.Add(diag.Errorf(rel.Pos, diag.Link, "imported label %s is not exported by any module", name))
+				}
+				return e.addr, ok
+			}
+			if addr, ok := variables[name]; ok {
+				return addr, true
+			}
+			if unallocated[name] {
+				// reported once, where the first variable without RAM is used
+				if !outOfRAM {
+					diags.Add(diag.Errorf(rel.Pos, diag.OutOfRAM, "no RAM left for variable %s, variables would overrun SCREEN at %d", name, assembler.VarEnd))
+					outOfRAM = true
+				}
+				return 0, false
+			}
+			diags.Add(diag.Errorf(rel.Pos, diag.Link, "%s is not a label, import or variable of module %s", name, o.Module))
+			return 0, false
+		}
+
+		module := append([]string{}, o.Words...)
+		for _, rel := range o.Relocations {
+			var addr int
+			var ok bool
+			if rel.Expr == "" {
+				addr, ok = resolve(rel, rel.Symbol)
+			} else {
+				addr, ok = evaluate(rel, resolve, diags)
+			}
+			if ok {
+				module[rel.Addr] = fmt.Sprintf("0%015b", addr)
+			}
+		}
+		words = append(words, module...)
+	}
+	return words, symbols, diags.Err()
+}
+
+// evaluate a relocation's expression, reporting problems to diags
+func evaluate(rel object.Relocation, resolve func(object.Relocation, string) (int, bool), diags *diag.List) (int, bool) {
+	var v int
+	e, err := expr.Parse(rel.Expr)
+	if err == nil {
+		v, err = expr.Eval(e, func(name string) (int, bool) { return resolve(rel, name) })
+	}
+	if _, ok := err.(*expr.UndefinedError); ok {
+		// already reported by resolve
+		return 0, false
+	}
+	if err != nil {
+		diags.Add(diag.Errorf(rel.Pos, diag.Expr, "%v in expression %s", err, rel.Expr))
+		return 0, false
+	}
+	if v < 0 || v > command.MaxAddress {
+		diags.Add(diag.Errorf(rel.Pos, diag.BadAddress, "expression %s is %d, out of range 0..%d", rel.Expr, v, command.MaxAddress))
+		return 0, false
+	}
+	return v, true
+}
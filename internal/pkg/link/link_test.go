@@ -0,0 +1,105 @@
+package link
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// compile compiles src as a module named name
+func compile(t *testing.T, name, src string) *object.Object {
+	tokens, err := lex.TokenizeFile(name+".asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	o, err := assembler.Options{}.Compile(name, program, nil)
+	assert.NoError(t, err)
+	return o
+}
+
+func TestLink(t *testing.T) {
+	main := compile(t, "main", ".import INC\n@n\nM=0\n(LOOP)\n@INC\n0;JMP\n@LOOP\n")
+	inc := compile(t, "inc", ".export INC\n@x\n(INC)\n@n\nM=M+1\n@INC\n")
+	words, symbols, err := Link([]*object.Object{main, inc}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"0000000000010000", // @n, main's variable at 16
+		"1110101010001000",
+		"0000000000000110", // @INC, relocated after main's 5 words
+		"1110101010000111",
+		"0000000000000010", // @LOOP
+		"0000000000010001", // @x, inc's variable at 17
+		"0000000000010010", // @n, inc's own n at 18
+		"1111110111001000",
+		"0000000000000110",
+	}, words)
+
+	for name, value := range map[string]int{"INC": 6, "main:LOOP": 2, "main:n": 16, "inc:x": 17, "inc:n": 18} {
+		sym, ok := symbols.Lookup(name)
+		assert.True(t, ok, name)
+		assert.Equal(t, value, sym.Value, name)
+	}
+	_, ok := symbols.Lookup("LOOP")
+	assert.False(t, ok)
+}
+
+func TestLinkErrors(t *testing.T) {
+	a := compile(t, "a", ".export X\n.import Y\n(X)\n@Y\n@Y\n")
+	b := compile(t, "b", ".export X\n(X)\n@X\n")
+	var diags diag.List
+	_, _, err := Link([]*object.Object{a, b}, &diags)
+	assert.Error(t, err)
+	diags.Sort()
+	assert.Equal(t, `a.asm:4:1: error[E012]: imported label Y is not exported by any module
+a.asm:5:1: error[E012]: imported label Y is not exported by any module
+b.asm:2:1: error[E012]: label X already exported by module a at a.asm:3:1`, diags.Error())
+}
+
+func TestLinkOutOfRAM(t *testing.T) {
+	var vars []string
+	for i := assembler.VarStart; i < assembler.VarEnd; i++ {
+		vars = append(vars, "v")
+	}
+	a := &object.Object{Module: "a", Variables: vars}
+	b := &object.Object{
+		Module:    "b",
+		Words:     []string{"0000000000000000", "0000000000000000", "0000000000000000"},
+		Variables: []string{"w", "x"},
+		Relocations: []object.Relocation{
+			{Addr: 0, Symbol: "w", Pos: token.Pos{File: "b.asm", Line: 1, Col: 1}},
+			{Addr: 1, Symbol: "x", Pos: token.Pos{File: "b.asm", Line: 2, Col: 1}},
+			{Addr: 2, Symbol: "w", Pos: token.Pos{File: "b.asm", Line: 3, Col: 1}},
+		},
+	}
+	_, _, err := Link([]*object.Object{a, b}, nil)
+	assert.EqualError(t, err, "b.asm:1:1: error[E007]: no RAM left for variable w, variables would overrun SCREEN at 16384")
+}
+
+func TestLinkExpressions(t *testing.T) {
+	main := compile(t, "main", ".equ W 32\n.import LIB\n@W*2\n(TOP)\n@TOP+1\n@LIB-1\n")
+	assert.Equal(t, []object.Relocation{
+		{Addr: 1, Expr: "TOP+1", Pos: token.Pos{File: "main.asm", Line: 5, Col: 1}},
+		{Addr: 2, Expr: "LIB-1", Pos: token.Pos{File: "main.asm", Line: 6, Col: 1}},
+	}, main.Relocations)
+	lib := compile(t, "lib", ".export LIB\n(LIB)\n@LIB-3\n")
+	words, _, err := Link([]*object.Object{main, lib}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"0000000001000000", // W*2, folded when compiled
+		"0000000000000010", // TOP+1
+		"0000000000000010", // LIB-1
+		"0000000000000000", // LIB-3
+	}, words)
+
+	lib = compile(t, "lib", ".export LIB\n(LIB)\n@LIB-4\n")
+	_, _, err = Link([]*object.Object{main, lib}, nil)
+	assert.EqualError(t, err, "lib.asm:3:1: error[E006]: expression LIB-4 is -1, out of range 0..32767")
+}
@@ -0,0 +1,137 @@
+package listing
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// header of the listing columns, matching the layout of each row
+const header = "ADDR   BINARY            HEX    LINE  SOURCE"
+
+// word is one assembled instruction and its ROM address
+type word struct {
+	addr int
+	bits string
+}
+
+// Source is a file given to the assembler.
+type Source struct {
+	Name string
+	Text string
+}
+
+// line identifies a source line
+type line struct {
+	file string
+	n    int
+}
+
+// Write a listing of src to w. Each source line is printed with the ROM
+// address and machine word of the instruction assembled from it, lines
+// without an instruction (comments, blanks, labels) are printed as-is. A
+// macro call or include is printed with its first instruction, the rest of
+// its expansion follows without source. instructions must be the output of
+// assembling program.
+func Write(w io.Writer, src string, program command.Program, instructions []string) error {
+	return Options{}.WriteFiles(w, []Source{{Text: src}}, program, instructions)
+}
+
+// WriteFiles writes a listing with default Options, see Options.WriteFiles.
+func WriteFiles(w io.Writer, sources []Source, program command.Program, instructions []string) error {
+	return Options{}.WriteFiles(w, sources, program, instructions)
+}
+
+// Options change how listings are written.
+type Options struct {
+	// Hex prints ROM addresses in hexadecimal
+	Hex bool
+}
+
+// WriteFiles writes a listing of a program assembled from several files,
+// each file's lines following its name. See Write.
+func (o Options) WriteFiles(w io.Writer, sources []Source, program command.Program, instructions []string) error {
+	words := map[line][]word{}
+	addr := 0
+	for _, c := range program {
+		var pos token.Pos
+		switch cmd := c.(type) {
+		case command.A:
+			pos = cmd.Pos.Site()
+		case command.C:
+			pos = cmd.Pos.Site()
+		default:
+			continue
+		}
+		if addr >= len(instructions) {
+			return fmt.Errorf("program has more instructions than the %d assembled", len(instructions))
+		}
+		if len(sources) == 1 {
+			pos.File = sources[0].Name
+		}
+		l := line{pos.File, pos.Line}
+		words[l] = append(words[l], word{addr: addr, bits: instructions[addr]})
+		addr++
+	}
+
+	if _, err := fmt.Fprintln(w, header); err != nil {
+		return err
+	}
+	for _, src := range sources {
+		if len(sources) > 1 {
+			if _, err := fmt.Fprintf(w, "%31s%s:\n", "", src.Name); err != nil {
+				return err
+			}
+		}
+		if err := o.writeLines(w, src, words); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeLines writes the listing of one source
+func (o Options) writeLines(w io.Writer, src Source, words map[line][]word) error {
+	addr := "%05d"
+	if o.Hex {
+		addr = "%05X"
+	}
+	for i, text := range Lines(src.Text) {
+		var err error
+		wds := words[line{src.Name, i + 1}]
+		if len(wds) == 0 {
+			_, err = fmt.Fprintf(w, "%31s%5d  %s\n", "", i+1, text)
+		}
+		for j, wd := range wds {
+			hex, _ := strconv.ParseUint(wd.bits, 2, 16)
+			if j == 0 {
+				_, err = fmt.Fprintf(w, addr+"  %s  %04X  %5d  %s\n", wd.addr, wd.bits, hex, i+1, text)
+			} else {
+				_, err = fmt.Fprintf(w, addr+"  %s  %04X\n", wd.addr, wd.bits, hex)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Lines splits src the same way the lexer reads it, dropping line endings.
+func Lines(src string) []string {
+	if src == "" {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(src, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimSuffix(l, "\r")
+	}
+	return lines
+}
@@ -0,0 +1,103 @@
+package listing
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+func TestWrite(t *testing.T) {
+	src := "// loop forever\r\n(END)\r\n  @END // top\r\n  0;JMP\r\n"
+	prog := command.Program{
+		command.L{Symbol: "END", Pos: token.Pos{Line: 2, Col: 1}},
+		command.A{Symbol: "END", Pos: token.Pos{Line: 3, Col: 3}},
+		command.C{C: "0", J: "JMP", Pos: token.Pos{Line: 4, Col: 3}},
+	}
+	var b bytes.Buffer
+	err := Write(&b, src, prog, []string{"0000000000000000", "1110101010000111"})
+	assert.NoError(t, err)
+	assert.Equal(t, `ADDR   BINARY            HEX    LINE  SOURCE
+                                   1  // loop forever
+                                   2  (END)
+00000  0000000000000000  0000      3    @END // top
+00001  1110101010000111  EA87      4    0;JMP
+`, b.String())
+
+	err = Write(&b, src, prog, []string{"0000000000000000"})
+	assert.Error(t, err)
+}
+
+func TestWriteMacro(t *testing.T) {
+	src := ".macro INC\n  M=M+1\n.endm\n  @i\n  INC\n"
+	call := token.Pos{Line: 5, Col: 3}
+	prog := command.Program{
+		command.A{Symbol: "i", Pos: token.Pos{Line: 4, Col: 3}},
+		command.C{D: command.Dest{M: true}, C: "M+1", Pos: token.Pos{Line: 2, Col: 3, Expansion: &call}},
+		command.C{D: command.Dest{M: true}, C: "M+1", Pos: token.Pos{Line: 2, Col: 3, Expansion: &call}},
+	}
+	var b bytes.Buffer
+	err := Write(&b, src, prog, []string{"0000000000010000", "1111110111001000", "1111110111001000"})
+	assert.NoError(t, err)
+	assert.Equal(t, `ADDR   BINARY            HEX    LINE  SOURCE
+                                   1  .macro INC
+                                   2    M=M+1
+                                   3  .endm
+00000  0000000000010000  0010      4    @i
+00001  1111110111001000  FDC8      5    INC
+00002  1111110111001000  FDC8
+`, b.String())
+}
+
+func TestWriteFiles(t *testing.T) {
+	sources := []Source{{Name: "a.asm", Text: "@1\n"}, {Name: "b.asm", Text: "// b\nD=A\n"}}
+	prog := command.Program{
+		command.A{Address: 1, Static: true, Pos: token.Pos{File: "a.asm", Line: 1, Col: 1}},
+		command.C{D: command.Dest{D: true}, C: "A", Pos: token.Pos{File: "b.asm", Line: 2, Col: 1}},
+	}
+	var b bytes.Buffer
+	err := WriteFiles(&b, sources, prog, []string{"0000000000000001", "1110110000010000"})
+	assert.NoError(t, err)
+	assert.Equal(t, `ADDR   BINARY            HEX    LINE  SOURCE
+                               a.asm:
+00000  0000000000000001  0001      1  @1
+                               b.asm:
+                                   1  // b
+00001  1110110000010000  EC10      2  D=A
+`, b.String())
+}
+
+func TestWriteHex(t *testing.T) {
+	src := "@0x4000\n"
+	var prog command.Program
+	var words []string
+	for i := 0; i < 11; i++ {
+		prog = append(prog, command.A{Address: 16384, Static: true, Text: "0x4000", Pos: token.Pos{Line: 1, Col: 1}})
+		words = append(words, "0100000000000000")
+	}
+	var b bytes.Buffer
+	err := Options{Hex: true}.WriteFiles(&b, []Source{{Text: src}}, prog, words)
+	assert.NoError(t, err)
+	assert.Equal(t, `ADDR   BINARY            HEX    LINE  SOURCE
+00000  0100000000000000  4000      1  @0x4000
+00001  0100000000000000  4000
+00002  0100000000000000  4000
+00003  0100000000000000  4000
+00004  0100000000000000  4000
+00005  0100000000000000  4000
+00006  0100000000000000  4000
+00007  0100000000000000  4000
+00008  0100000000000000  4000
+00009  0100000000000000  4000
+0000A  0100000000000000  4000
+`, b.String())
+}
+
+func TestLines(t *testing.T) {
+	assert.Nil(t, Lines(""))
+	assert.Equal(t, []string{"a", "", "b"}, Lines("a\n\nb"))
+	assert.Equal(t, []string{"a", "b"}, Lines("a\r\nb\r\n"))
+}
@@ -0,0 +1,332 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/listing"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/preproc"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// validSymbol matches the symbols the lexer accepts after @
+var validSymbol = regexp.MustCompile(`^[A-Za-z_.$:][A-Za-z0-9_.$:]*$`)
+
+// ref is a use or definition of a symbol in the source
+type ref struct {
+	name string
+	pos  token.Pos
+	def  bool
+}
+
+// instruction is an A or C command with its ROM address
+type instruction struct {
+	cmd  command.Any
+	pos  token.Pos
+	addr int
+	word string
+}
+
+// document is an open file, analyzed on every change
+type document struct {
+	uri string
+	// name is the file path of the uri, resolving includes
+	name         string
+	lines        []string
+	symbols      *assembler.SymbolTable
+	refs         []ref
+	instructions []instruction
+	diags        diag.List
+}
+
+// analyze text, keeping going after errors to learn as much as possible
+func analyze(uri, text string) *document {
+	d := &document{uri: uri, name: path(uri), lines: listing.Lines(text)}
+	tokens, err := preproc.TokenizeFile(d.name, strings.NewReader(text), &d.diags)
+	if _, ok := err.(diag.List); err != nil && !ok {
+		d.diags.Add(err)
+	}
+	program, _ := parser.Parse(tokens, &d.diags)
+	words, symbols, _ := assembler.Assemble(program, &d.diags)
+	d.symbols = symbols
+	d.diags.Sort()
+
+	for _, c := range program {
+		switch cmd := c.(type) {
+		case command.L:
+			if cmd.Pos.Expansion != nil {
+				// local to a macro expansion, renamed from the body
+				continue
+			}
+			d.refs = append(d.refs, ref{name: cmd.Symbol, pos: at(cmd.Pos, 1), def: true})
+		case command.Equ:
+			if cmd.Pos.Expansion == nil {
+				d.refs = append(d.refs, ref{name: cmd.Name, pos: cmd.Pos, def: true})
+			}
+		case command.A:
+			if cmd.Expr != nil && cmd.Pos.Expansion == nil {
+				for _, s := range expr.Symbols(cmd.Expr) {
+					d.refs = append(d.refs, ref{name: s.Name, pos: at(cmd.Pos, 1+s.Offset)})
+				}
+			} else if !cmd.Static && cmd.Pos.Expansion == nil {
+				d.refs = append(d.refs, ref{name: cmd.Symbol, pos: at(cmd.Pos, 1)})
+			}
+			d.instructions = append(d.instructions, instruction{cmd: cmd, pos: cmd.Pos, addr: len(d.instructions)})
+		case command.C:
+			d.instructions = append(d.instructions, instruction{cmd: cmd, pos: cmd.Pos, addr: len(d.instructions)})
+		}
+	}
+	// words are only produced for programs without errors
+	if len(words) == len(d.instructions) {
+		for i := range d.instructions {
+			d.instructions[i].word = words[i]
+		}
+	}
+	return d
+}
+
+// path returns the file path of a file URI, else the URI itself
+func path(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+// fileURI returns the file URI of a file path
+func fileURI(name string) (string, error) {
+	name, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	name = filepath.ToSlash(name)
+	if !strings.HasPrefix(name, "/") {
+		// a Windows drive letter
+		name = "/" + name
+	}
+	return (&url.URL{Scheme: "file", Path: name}).String(), nil
+}
+
+// diagnostics in LSP form
+func (d *document) diagnostics() []Diagnostic {
+	result := []Diagnostic{}
+	for _, e := range d.diags {
+		severity := severityError
+		if e.Severity == diag.Warning {
+			severity = severityWarning
+		}
+		message := e.Message
+		if e.Suggestion != "" {
+			message += fmt.Sprintf(" (did you mean %s?)", e.Suggestion)
+		}
+		// problems in included files are shown at the .include
+		pos := e.Pos
+		for pos.File != d.name && pos.Expansion != nil {
+			pos = *pos.Expansion
+		}
+		if pos.File != e.Pos.File {
+			message = fmt.Sprintf("%s:%d:%d: %s", e.Pos.File, e.Pos.Line, e.Pos.Col, message)
+		}
+		result = append(result, Diagnostic{
+			Range:    d.word(pos),
+			Severity: severity,
+			Code:     string(e.Code),
+			Source:   "n2t-asm",
+			Message:  message,
+		})
+	}
+	return result
+}
+
+// word returns the range of the non-space run starting at pos
+func (d *document) word(pos token.Pos) Range {
+	if pos.Line < 1 {
+		return Range{}
+	}
+	start := Position{Line: pos.Line - 1, Character: pos.Col - 1}
+	end := start
+	if pos.Line <= len(d.lines) {
+		line := d.lines[pos.Line-1]
+		for end.Character < len(line) && !unicode.IsSpace(rune(line[end.Character])) {
+			end.Character++
+		}
+	}
+	if end == start {
+		end.Character++
+	}
+	return Range{Start: start, End: end}
+}
+
+// span returns the range of a reference
+func (r ref) span() Range {
+	start := Position{Line: r.pos.Line - 1, Character: r.pos.Col - 1}
+	return Range{Start: start, End: Position{Line: start.Line, Character: start.Character + len(r.name)}}
+}
+
+// refAt returns the reference under the cursor
+func (d *document) refAt(p Position) (ref, bool) {
+	for _, r := range d.refs {
+		s := r.span()
+		if s.Start.Line == p.Line && s.Start.Character <= p.Character && p.Character <= s.End.Character {
+			return r, true
+		}
+	}
+	return ref{}, false
+}
+
+// instructionAt returns the instruction on the cursor's line, the first one
+// produced by a macro call or .include
+func (d *document) instructionAt(p Position) (instruction, bool) {
+	for _, in := range d.instructions {
+		if site := in.pos.Site(); site.File == d.name && site.Line-1 == p.Line {
+			return in, true
+		}
+	}
+	return instruction{}, false
+}
+
+// refs to name, optionally excluding label definitions
+func (d *document) refsTo(name string, definitions bool) []ref {
+	var result []ref
+	for _, r := range d.refs {
+		if r.name == name && (definitions || !r.def) {
+			result = append(result, r)
+		}
+	}
+	return result
+}
+
+// definition of the symbol under the cursor
+func (d *document) definition(p Position) (Location, bool) {
+	r, ok := d.refAt(p)
+	if !ok {
+		return Location{}, false
+	}
+	sym, ok := d.symbols.Lookup(r.name)
+	if !ok || sym.Pos == nil {
+		// predefined
+		return Location{}, false
+	}
+	// labels are defined at '(', variables at their first '@', constants at
+	// their name
+	def := ref{name: r.name, pos: at(*sym.Pos, 1)}
+	if sym.Kind == assembler.Constant {
+		def.pos = *sym.Pos
+	}
+	uri := d.uri
+	if sym.Pos.File != d.name {
+		// in an included file
+		var err error
+		if uri, err = fileURI(sym.Pos.File); err != nil {
+			return Location{}, false
+		}
+	}
+	return Location{URI: uri, Range: def.span()}, true
+}
+
+// hover describes the symbol and instruction under the cursor
+func (d *document) hover(p Position) (Hover, bool) {
+	var parts []string
+	var span *Range
+	if r, ok := d.refAt(p); ok {
+		if sym, ok := d.symbols.Lookup(r.name); ok {
+			switch sym.Kind {
+			case assembler.Label:
+				parts = append(parts, fmt.Sprintf("**%s** %s, ROM[%d]", sym.Name, sym.Kind, sym.Value))
+			case assembler.Constant:
+				parts = append(parts, fmt.Sprintf("**%s** %s, %d", sym.Name, sym.Kind, sym.Value))
+			default:
+				parts = append(parts, fmt.Sprintf("**%s** %s, RAM[%d]", sym.Name, sym.Kind, sym.Value))
+			}
+			s := r.span()
+			span = &s
+		}
+	}
+	if in, ok := d.instructionAt(p); ok {
+		s := fmt.Sprintf("ROM[%d]", in.addr)
+		if in.word != "" {
+			var v uint64
+			fmt.Sscanf(in.word, "%b", &v)
+			s += fmt.Sprintf(" `%s` 0x%04X", in.word, v)
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return Hover{}, false
+	}
+	return Hover{Contents: MarkupContent{Kind: "markdown", Value: strings.Join(parts, "\n\n")}, Range: span}, true
+}
+
+// completion offers symbols after @, jumps after ; and comps elsewhere
+func (d *document) completion(p Position) []CompletionItem {
+	prefix := ""
+	if p.Line < len(d.lines) {
+		line := d.lines[p.Line]
+		if p.Character < len(line) {
+			line = line[:p.Character]
+		}
+		prefix = strings.TrimSpace(line)
+	}
+	var result []CompletionItem
+	switch {
+	case strings.HasPrefix(prefix, "@"):
+		for _, sym := range d.symbols.Symbols() {
+			kind := kindVariable
+			switch sym.Kind {
+			case assembler.Label:
+				kind = kindLabel
+			case assembler.Predefined, assembler.Constant:
+				kind = kindConstant
+			}
+			result = append(result, CompletionItem{Label: sym.Name, Kind: kind, Detail: fmt.Sprintf("%s %d", sym.Kind, sym.Value)})
+		}
+	case strings.Contains(prefix, ";"):
+		for _, j := range assembler.JumpMnemonics() {
+			result = append(result, CompletionItem{Label: j, Kind: kindKeyword, Detail: "jump"})
+		}
+	default:
+		for _, c := range assembler.CompMnemonics() {
+			result = append(result, CompletionItem{Label: c, Kind: kindKeyword, Detail: "computation"})
+		}
+	}
+	return result
+}
+
+// rename the label or variable under the cursor
+func (d *document) rename(p Position, name string) (WorkspaceEdit, error) {
+	r, ok := d.refAt(p)
+	if !ok {
+		return WorkspaceEdit{}, fmt.Errorf("no symbol to rename")
+	}
+	sym, ok := d.symbols.Lookup(r.name)
+	if !ok || sym.Kind == assembler.Predefined {
+		return WorkspaceEdit{}, fmt.Errorf("cannot rename %s", r.name)
+	}
+	if !validSymbol.MatchString(name) {
+		return WorkspaceEdit{}, fmt.Errorf("%q is not a valid symbol", name)
+	}
+	if _, ok := d.symbols.Lookup(name); ok {
+		return WorkspaceEdit{}, fmt.Errorf("%s is already defined", name)
+	}
+	var edits []TextEdit
+	for _, r := range d.refsTo(r.name, true) {
+		edits = append(edits, TextEdit{Range: r.span(), NewText: name})
+	}
+	return WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+}
+
+// at returns pos moved right by offset columns
+func at(pos token.Pos, offset int) token.Pos {
+	pos.Col += offset
+	return pos
+}
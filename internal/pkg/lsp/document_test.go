@@ -0,0 +1,146 @@
+package lsp
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const prog = `// count to 3
+    @i
+    M=1
+(LOOP)
+    @i
+    M=M+1
+    @LOOP
+    0;JMP
+`
+
+func TestDefinitionAndReferences(t *testing.T) {
+	d := analyze("file:///p.asm", prog)
+	assert.Empty(t, d.diagnostics())
+
+	// cursor on the i of line 5, @i
+	loc, ok := d.definition(Position{Line: 4, Character: 5})
+	assert.True(t, ok)
+	assert.Equal(t, Range{Start: Position{Line: 1, Character: 5}, End: Position{Line: 1, Character: 6}}, loc.Range)
+
+	loc, ok = d.definition(Position{Line: 6, Character: 8})
+	assert.True(t, ok)
+	assert.Equal(t, Range{Start: Position{Line: 3, Character: 1}, End: Position{Line: 3, Character: 5}}, loc.Range)
+
+	_, ok = d.definition(Position{Line: 2, Character: 5})
+	assert.False(t, ok)
+
+	r, ok := d.refAt(Position{Line: 3, Character: 2})
+	assert.True(t, ok)
+	assert.Len(t, d.refsTo(r.name, true), 2)
+	assert.Len(t, d.refsTo(r.name, false), 1)
+}
+
+func TestHover(t *testing.T) {
+	d := analyze("file:///p.asm", prog)
+	h, ok := d.hover(Position{Line: 6, Character: 6})
+	assert.True(t, ok)
+	assert.Equal(t, "**LOOP** label, ROM[2]\n\nROM[4] `0000000000000010` 0x0002", h.Contents.Value)
+	assert.Equal(t, &Range{Start: Position{Line: 6, Character: 5}, End: Position{Line: 6, Character: 9}}, h.Range)
+
+	h, ok = d.hover(Position{Line: 7, Character: 0})
+	assert.True(t, ok)
+	assert.Equal(t, "ROM[5] `1110101010000111` 0xEA87", h.Contents.Value)
+
+	_, ok = d.hover(Position{Line: 0, Character: 3})
+	assert.False(t, ok)
+}
+
+func TestConstant(t *testing.T) {
+	d := analyze("file:///c.asm", ".equ  WIDTH 32\n    @WIDTH\n    @SCREEN+WIDTH*2\n")
+	assert.Empty(t, d.diagnostics())
+	refs := d.refsTo("WIDTH", true)
+	assert.Len(t, refs, 3)
+	assert.Equal(t, Range{Start: Position{Line: 2, Character: 12}, End: Position{Line: 2, Character: 17}}, refs[2].span())
+	loc, ok := d.definition(Position{Line: 1, Character: 6})
+	assert.True(t, ok)
+	assert.Equal(t, Range{Start: Position{Line: 0, Character: 6}, End: Position{Line: 0, Character: 11}}, loc.Range)
+
+	h, ok := d.hover(Position{Line: 0, Character: 7})
+	assert.True(t, ok)
+	assert.Equal(t, "**WIDTH** constant, 32", h.Contents.Value)
+}
+
+func TestDiagnostics(t *testing.T) {
+	d := analyze("file:///p.asm", "@i\nM=M&1\n0;JPM\n")
+	assert.Equal(t, []Diagnostic{
+		{Range: Range{Start: Position{Line: 1, Character: 0}, End: Position{Line: 1, Character: 5}}, Severity: severityError, Code: "E002", Source: "n2t-asm", Message: "unknown computation: M&1 (did you mean M+1?)"},
+		{Range: Range{Start: Position{Line: 2, Character: 2}, End: Position{Line: 2, Character: 5}}, Severity: severityError, Code: "E003", Source: "n2t-asm", Message: "unknown jump 'JPM' in: 0;JPM (did you mean JMP?)"},
+	}, d.diagnostics())
+	// the hover still knows the address without the encoded word
+	h, ok := d.hover(Position{Line: 0, Character: 1})
+	assert.True(t, ok)
+	assert.Equal(t, "**i** variable, RAM[16]\n\nROM[0]", h.Contents.Value)
+}
+
+func TestCompletion(t *testing.T) {
+	d := analyze("file:///p.asm", prog+"    @\n    D;\n    D=\n")
+	labels := func(items []CompletionItem) []string {
+		var result []string
+		for _, item := range items {
+			result = append(result, item.Label)
+		}
+		return result
+	}
+	symbols := labels(d.completion(Position{Line: 8, Character: 5}))
+	assert.Contains(t, symbols, "LOOP")
+	assert.Contains(t, symbols, "i")
+	assert.Contains(t, symbols, "SCREEN")
+	assert.Equal(t, []string{"JEQ", "JGE", "JGT", "JLE", "JLT", "JMP", "JNE"}, labels(d.completion(Position{Line: 9, Character: 6})))
+	assert.Contains(t, labels(d.completion(Position{Line: 10, Character: 6})), "D+M")
+}
+
+func TestRename(t *testing.T) {
+	d := analyze("file:///p.asm", prog)
+	edit, err := d.rename(Position{Line: 3, Character: 3}, "AGAIN")
+	assert.NoError(t, err)
+	assert.Equal(t, []TextEdit{
+		{Range: Range{Start: Position{Line: 3, Character: 1}, End: Position{Line: 3, Character: 5}}, NewText: "AGAIN"},
+		{Range: Range{Start: Position{Line: 6, Character: 5}, End: Position{Line: 6, Character: 9}}, NewText: "AGAIN"},
+	}, edit.Changes["file:///p.asm"])
+
+	for _, name := range []string{"i", "SCREEN", "1x", "a b"} {
+		_, err = d.rename(Position{Line: 3, Character: 3}, name)
+		assert.Error(t, err, name)
+	}
+	_, err = d.rename(Position{Line: 2, Character: 4}, "x")
+	assert.Error(t, err)
+}
+
+func TestIncludeDiagnostics(t *testing.T) {
+	dir := t.TempDir()
+	math := filepath.Join(dir, "math.asm")
+	assert.NoError(t, ioutil.WriteFile(math, []byte("@1\nD=X\n"), 0644))
+
+	d := analyze("file://"+filepath.ToSlash(filepath.Join(dir, "main.asm")), "@0\n  .include \"math.asm\"\n")
+	diags := d.diagnostics()
+	assert.Len(t, diags, 1)
+	assert.Equal(t, Range{Start: Position{Line: 1, Character: 2}, End: Position{Line: 1, Character: 10}}, diags[0].Range)
+	assert.Equal(t, math+":2:3: unexpected rune 'X' in: D=X", diags[0].Message)
+
+	lib := filepath.Join(dir, "lib.asm")
+	assert.NoError(t, ioutil.WriteFile(lib, []byte("(ADD)\nD=A\n"), 0644))
+	d = analyze("file://"+filepath.ToSlash(filepath.Join(dir, "main.asm")), "  .include \"lib.asm\"\n@ADD\n0;JMP\n")
+	assert.Empty(t, d.diagnostics())
+
+	// instructions are found at their .include, not at their line in lib.asm
+	h, ok := d.hover(Position{Line: 0, Character: 3})
+	assert.True(t, ok)
+	assert.Equal(t, "ROM[0] `1110110000010000` 0xEC10", h.Contents.Value)
+	h, ok = d.hover(Position{Line: 1, Character: 0})
+	assert.True(t, ok)
+	assert.Equal(t, "ROM[1] `0000000000000000` 0x0000", h.Contents.Value)
+
+	loc, ok := d.definition(Position{Line: 1, Character: 2})
+	assert.True(t, ok)
+	assert.Equal(t, Location{URI: "file://" + filepath.ToSlash(lib), Range: Range{Start: Position{Line: 0, Character: 1}, End: Position{Line: 0, Character: 4}}}, loc)
+}
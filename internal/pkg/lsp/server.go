@@ -0,0 +1,220 @@
+// Package lsp implements a Language Server Protocol server for Hack assembly,
+// publishing diagnostics and answering symbol queries from editors.
+package lsp
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/wire"
+)
+
+// Server answers requests for the documents an editor has open.
+type Server struct {
+	w        *wire.Writer
+	docs     map[string]*document
+	shutdown bool
+	// err is the first failure writing a notification
+	err error
+}
+
+// Serve messages read from in, writing responses and notifications to out,
+// until the client sends exit or in ends.
+func (s *Server) Serve(in io.Reader, out io.Writer) error {
+	r := wire.NewReader(in)
+	s.w = wire.NewWriter(out)
+	s.docs = map[string]*document{}
+	for {
+		body, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.reply(nil, nil, &responseError{Code: parseError, Message: err.Error()}); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("exit without shutdown")
+			}
+			return nil
+		}
+		result, rerr := s.handle(msg)
+		if s.err != nil {
+			return s.err
+		}
+		// notifications have no id and get no response
+		if msg.ID == nil {
+			continue
+		}
+		if err := s.reply(msg.ID, result, rerr); err != nil {
+			return err
+		}
+	}
+}
+
+// handle a message, returning the result for requests
+func (s *Server) handle(msg message) (interface{}, *responseError) {
+	switch msg.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":   1, // full
+				"hoverProvider":      true,
+				"definitionProvider": true,
+				"referencesProvider": true,
+				"renameProvider":     true,
+				"completionProvider": map[string]interface{}{"triggerCharacters": []string{"@", "=", ";"}},
+			},
+			"serverInfo": map[string]string{"name": "n2t-asm"},
+		}, nil
+	case "initialized":
+		return nil, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		s.update(p.TextDocument.URI, p.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		if n := len(p.ContentChanges); n > 0 {
+			s.update(p.TextDocument.URI, p.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		delete(s.docs, p.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+	case "textDocument/definition":
+		d, p, rerr := s.position(msg.Params)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if loc, ok := d.definition(p.Position); ok {
+			return loc, nil
+		}
+		return nil, nil
+	case "textDocument/references":
+		var p ReferenceParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		d, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, unknown(p.TextDocument.URI)
+		}
+		locations := []Location{}
+		if r, ok := d.refAt(p.Position); ok {
+			for _, r := range d.refsTo(r.name, p.Context.IncludeDeclaration) {
+				locations = append(locations, Location{URI: d.uri, Range: r.span()})
+			}
+		}
+		return locations, nil
+	case "textDocument/hover":
+		d, p, rerr := s.position(msg.Params)
+		if rerr != nil {
+			return nil, rerr
+		}
+		if h, ok := d.hover(p.Position); ok {
+			return h, nil
+		}
+		return nil, nil
+	case "textDocument/completion":
+		d, p, rerr := s.position(msg.Params)
+		if rerr != nil {
+			return nil, rerr
+		}
+		return d.completion(p.Position), nil
+	case "textDocument/rename":
+		var p RenameParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			return nil, badParams(err)
+		}
+		d, ok := s.docs[p.TextDocument.URI]
+		if !ok {
+			return nil, unknown(p.TextDocument.URI)
+		}
+		edit, err := d.rename(p.Position, p.NewName)
+		if err != nil {
+			return nil, &responseError{Code: requestFailed, Message: err.Error()}
+		}
+		return edit, nil
+	}
+	return nil, &responseError{Code: methodNotFound, Message: "method not supported: " + msg.Method}
+}
+
+// update a document and publish its diagnostics
+func (s *Server) update(uri, text string) {
+	d := analyze(uri, text)
+	s.docs[uri] = d
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: d.diagnostics()})
+}
+
+// position decodes position params, returning the document they name
+func (s *Server) position(params json.RawMessage) (*document, TextDocumentPositionParams, *responseError) {
+	var p TextDocumentPositionParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, p, badParams(err)
+	}
+	d, ok := s.docs[p.TextDocument.URI]
+	if !ok {
+		return nil, p, unknown(p.TextDocument.URI)
+	}
+	return d, p, nil
+}
+
+func badParams(err error) *responseError {
+	return &responseError{Code: invalidParams, Message: err.Error()}
+}
+
+func unknown(uri string) *responseError {
+	return &responseError{Code: invalidParams, Message: "document not open: " + uri}
+}
+
+func (s *Server) reply(id json.RawMessage, result interface{}, rerr *responseError) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	msg := message{JSONRPC: "2.0", ID: id, Error: rerr}
+	if rerr == nil {
+		// a null result must still be sent
+		msg.Result = nullable{result}
+	}
+	return s.w.Write(msg)
+}
+
+// notify sends a notification, recording any failure in s.err
+func (s *Server) notify(method string, params interface{}) {
+	err := s.w.Write(notification{JSONRPC: "2.0", Method: method, Params: params})
+	if s.err == nil {
+		s.err = err
+	}
+}
+
+// nullable marshals as its value, or null, defeating omitempty on Result
+type nullable struct {
+	v interface{}
+}
+
+func (n nullable) MarshalJSON() ([]byte, error) {
+	return json.Marshal(n.v)
+}
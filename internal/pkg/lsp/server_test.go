@@ -0,0 +1,62 @@
+package lsp
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/wire"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServe(t *testing.T) {
+	var in bytes.Buffer
+	w := wire.NewWriter(&in)
+	send := func(id int, method string, params interface{}) {
+		msg := map[string]interface{}{"jsonrpc": "2.0", "method": method, "params": params}
+		if id != 0 {
+			msg["id"] = id
+		}
+		assert.NoError(t, w.Write(msg))
+	}
+	doc := map[string]string{"uri": "file:///p.asm"}
+	send(1, "initialize", map[string]interface{}{})
+	send(0, "initialized", map[string]interface{}{})
+	send(0, "textDocument/didOpen", map[string]interface{}{"textDocument": map[string]string{"uri": "file:///p.asm", "text": "@x\nM=M&1\n"}})
+	send(0, "textDocument/didChange", map[string]interface{}{"textDocument": doc, "contentChanges": []map[string]string{{"text": prog}}})
+	send(2, "textDocument/definition", map[string]interface{}{"textDocument": doc, "position": Position{Line: 6, Character: 6}})
+	send(3, "textDocument/hover", map[string]interface{}{"textDocument": doc, "position": Position{Line: 0, Character: 0}})
+	send(4, "textDocument/references", map[string]interface{}{"textDocument": doc, "position": Position{Line: 1, Character: 5}, "context": map[string]bool{"includeDeclaration": true}})
+	send(5, "textDocument/rename", map[string]interface{}{"textDocument": doc, "position": Position{Line: 1, Character: 5}, "newName": "LOOP"})
+	send(6, "textDocument/formatting", map[string]interface{}{"textDocument": doc})
+	send(0, "textDocument/didClose", map[string]interface{}{"textDocument": doc})
+	send(7, "shutdown", nil)
+	send(0, "exit", nil)
+
+	var out bytes.Buffer
+	assert.NoError(t, (&Server{}).Serve(&in, &out))
+
+	var got []string
+	r := wire.NewReader(&out)
+	for {
+		body, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		var compact bytes.Buffer
+		assert.NoError(t, json.Compact(&compact, body))
+		got = append(got, compact.String())
+	}
+	assert.Len(t, got, 10)
+	assert.Contains(t, got[0], `"id":1,"result":{"capabilities":{`)
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///p.asm","diagnostics":[{"range":{"start":{"line":1,"character":0},"end":{"line":1,"character":5}},"severity":1,"code":"E002","source":"n2t-asm","message":"unknown computation: M&1 (did you mean M+1?)"}]}}`, got[1])
+	assert.Equal(t, `{"jsonrpc":"2.0","method":"textDocument/publishDiagnostics","params":{"uri":"file:///p.asm","diagnostics":[]}}`, got[2])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":2,"result":{"uri":"file:///p.asm","range":{"start":{"line":3,"character":1},"end":{"line":3,"character":5}}}}`, got[3])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":3,"result":null}`, got[4])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":4,"result":[{"uri":"file:///p.asm","range":{"start":{"line":1,"character":5},"end":{"line":1,"character":6}}},{"uri":"file:///p.asm","range":{"start":{"line":4,"character":5},"end":{"line":4,"character":6}}}]}`, got[5])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":5,"error":{"code":-32803,"message":"LOOP is already defined"}}`, got[6])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":6,"error":{"code":-32601,"message":"method not supported: textDocument/formatting"}}`, got[7])
+	assert.Equal(t, `{"jsonrpc":"2.0","id":7,"result":null}`, got[9])
+}
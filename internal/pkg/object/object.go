@@ -0,0 +1,108 @@
+// Package object defines relocatable object files, holding a module
+// assembled on its own for the linker to combine with others.
+package object
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Format identifies object files and their version.
+const Format = "n2t-asm object 1"
+
+// Object is a separately assembled module. The linker chooses where its code
+// goes in ROM and where its variables go in RAM, so A instructions loading a
+// label, import or variable are left as relocations to patch once those are
+// known.
+type Object struct {
+	Format string `json:"format"`
+	Module string `json:"module"`
+	// Words are the encoded instructions, 16 binary digits each, zero for
+	// the A instructions of relocations.
+	Words []string `json:"words"`
+	// Labels defined by the module, at addresses relative to its first word.
+	Labels []Label `json:"labels"`
+	// Relocations are the A instructions loading a label, import or variable,
+	// or an expression of labels and imports.
+	Relocations []Relocation `json:"relocations"`
+	// Imports are the labels the module uses from others, which must be
+	// exported by exactly one module.
+	Imports []string `json:"imports"`
+	// Variables are the other symbols used but not defined by the module, in
+	// order of first use. Each is a RAM variable private to the module.
+	Variables []string `json:"variables"`
+}
+
+// Label is a label defined by a module. Only exported labels are visible to
+// other modules.
+type Label struct {
+	Name   string    `json:"name"`
+	Value  int       `json:"value"`
+	Export bool      `json:"export,omitempty"`
+	Pos    token.Pos `json:"pos"`
+}
+
+// Relocation is an A instruction at Addr, relative to the module's first
+// word, which loads Symbol, or the value of Expr if not empty.
+type Relocation struct {
+	Addr   int       `json:"addr"`
+	Symbol string    `json:"symbol,omitempty"`
+	Expr   string    `json:"expr,omitempty"`
+	Pos    token.Pos `json:"pos"`
+}
+
+// Target returns the symbol or expression a relocation loads.
+func (r Relocation) Target() string {
+	if r.Expr != "" {
+		return r.Expr
+	}
+	return r.Symbol
+}
+
+// Write the object as indented JSON.
+func (o *Object) Write(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(o)
+}
+
+// Read an object written by Write, checking it is well formed.
+func Read(r io.Reader) (*Object, error) {
+	var o Object
+	if err := json.NewDecoder(r).Decode(&o); err != nil {
+		return nil, err
+	}
+	if o.Format != Format {
+		return nil, fmt.Errorf("not an object file, format %q, expected %q", o.Format, Format)
+	}
+	for i, w := range o.Words {
+		if len(w) != 16 || strings.Trim(w, "01") != "" {
+			return nil, fmt.Errorf("word %d is not 16 binary digits: %q", i, w)
+		}
+	}
+	for _, l := range o.Labels {
+		if l.Value < 0 || l.Value > len(o.Words) {
+			return nil, fmt.Errorf("label %s at %d, outside the module's %d words", l.Name, l.Value, len(o.Words))
+		}
+	}
+	for _, rel := range o.Relocations {
+		if rel.Addr < 0 || rel.Addr >= len(o.Words) || o.Words[rel.Addr][0] != '0' {
+			return nil, fmt.Errorf("relocation of %s at %d is not an A instruction", rel.Target(), rel.Addr)
+		}
+		if (rel.Symbol == "") == (rel.Expr == "") {
+			return nil, fmt.Errorf("relocation at %d must have one of a symbol or expression", rel.Addr)
+		}
+		if rel.Expr != "" {
+			if _, err := expr.Parse(rel.Expr); err != nil {
+				return nil, fmt.Errorf("relocation at %d: %v", rel.Addr, err)
+			}
+		}
+	}
+	return &o, nil
+}
@@ -0,0 +1,43 @@
+package object
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+func TestReadWrite(t *testing.T) {
+	o := &Object{
+		Format:      Format,
+		Module:      "main",
+		Words:       []string{"0000000000000000", "1110101010000111"},
+		Labels:      []Label{{Name: "LOOP", Value: 0, Export: true, Pos: token.Pos{File: "main.asm", Line: 1, Col: 1}}},
+		Relocations: []Relocation{{Addr: 0, Symbol: "LOOP", Pos: token.Pos{File: "main.asm", Line: 2, Col: 1}}},
+		Imports:     []string{},
+		Variables:   []string{},
+	}
+	var b bytes.Buffer
+	assert.NoError(t, o.Write(&b))
+	read, err := Read(&b)
+	assert.NoError(t, err)
+	assert.Equal(t, o, read)
+}
+
+func TestReadErrors(t *testing.T) {
+	testCases := map[string]string{
+		`{"format": "n2t-asm object 0"}`:                                                                             `not an object file, format "n2t-asm object 0", expected "n2t-asm object 1"`,
+		`{"format": "n2t-asm object 1", "words": ["0101"]}`:                                                          `word 0 is not 16 binary digits: "0101"`,
+		`{"format": "n2t-asm object 1", "labels": [{"name": "X", "value": 1}]}`:                                      `label X at 1, outside the module's 0 words`,
+		`{"format": "n2t-asm object 1", "words": ["1110101010000111"], "relocations": [{"addr": 0, "symbol": "X"}]}`: `relocation of X at 0 is not an A instruction`,
+		`{"format": "n2t-asm object 1", "words": ["0000000000000000"], "relocations": [{"addr": 0}]}`:                `relocation at 0 must have one of a symbol or expression`,
+		`{"format": "n2t-asm object 1", "words": ["0000000000000000"], "relocations": [{"addr": 0, "expr": "X+"}]}`:  `relocation at 0: missing operand in expression X+`,
+	}
+	for src, expected := range testCases {
+		_, err := Read(strings.NewReader(src))
+		assert.EqualError(t, err, expected, src)
+	}
+}
@@ -1,10 +1,14 @@
 package parser
 
 import (
-	"fmt"
+	"errors"
 	"strconv"
+	"strings"
+	"unicode"
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
@@ -15,14 +19,20 @@ type state struct {
 	cmdC    command.C
 	cmdA    command.A
 	cmdL    command.L
+	diags   *diag.List
 }
 
-// Parse tokens to commands.
-func Parse(tokens []token.Token) (command.Program, error) {
+// Parse tokens to commands. Malformed statements are reported to diags and
+// skipped, the returned error is non-nil if diags holds any errors.
+func Parse(tokens []token.Token, diags *diag.List) (command.Program, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
 	s := &state{
 		program: command.Program{},
 		tokens:  tokens,
 		index:   -1,
+		diags:   diags,
 	}
 	return s.parse()
 }
@@ -31,23 +41,27 @@ func (s *state) parse() (command.Program, error) {
 	for !s.end() {
 		err := s.s()
 		if err != nil {
-			return nil, err
+			s.diags.Add(err)
+			s.skip()
 		}
 	}
-	return s.program, nil
+	return s.program, s.diags.Err()
 }
 
 func (s *state) s() error {
 	if s.peek(token.END) {
 		return s.accept(token.END)
 	}
-	if s.peek(token.LOCATION) || s.peek(token.OPERATOR) || s.peek(token.NUMBER) {
+	if s.peek(token.LOCATION) || s.peek(token.OPERATOR) || s.peek(token.NUMBER) || s.peek(token.RAWCOMP) || s.peek(token.JUMP) {
 		// init
-		s.cmdC = command.C{D: command.Dest{}}
+		s.cmdC = command.C{D: command.Dest{}, Pos: s.peekGet().Pos}
 		// parse
 		err := s.c()
 		if err != nil {
-			return fmt.Errorf("parse error for location/operator/number: %v", err)
+			return err
+		}
+		if s.cmdC.C == "" {
+			return diag.Errorf(s.cmdC.Pos, diag.MissingComp, "missing computation")
 		}
 		// store
 		s.program = append(s.program, s.cmdC)
@@ -55,24 +69,31 @@ func (s *state) s() error {
 		s.cmdA = command.A{}
 		err := s.a()
 		if err != nil {
-			return fmt.Errorf("parse error for AT: %v", err)
+			return err
 		}
 		s.program = append(s.program, s.cmdA)
+	} else if s.peek(token.DIRECTIVE) {
+		cmd, err := s.directive()
+		if err != nil {
+			return err
+		}
+		s.program = append(s.program, cmd)
 	} else if s.peek(token.LABEL) {
 		s.cmdL = command.L{}
 		err := s.l()
 		if err != nil {
-			return fmt.Errorf("parse error for label: %v", err)
+			return err
 		}
 		s.program = append(s.program, s.cmdL)
 	} else {
-		return fmt.Errorf("unexpected token, wut: %v", s.peekGet())
+		return s.errorf("unexpected token: %v", s.peekGet())
 	}
 	return s.s()
 }
 
 // l parses type l commands, syntax (symbol)
 func (s *state) l() error {
+	pos := s.peekGet().Pos
 	err := s.accept(token.LABEL)
 	if err != nil {
 		return err
@@ -82,16 +103,70 @@ func (s *state) l() error {
 		if err != nil {
 			return err
 		}
-		s.cmdL = command.L{Symbol: s.tokens[s.index].Value}
+		s.cmdL = command.L{Symbol: s.tokens[s.index].Value, Pos: pos}
 	}
 	if !s.peek(token.END) {
-		return fmt.Errorf("malforned label syntax, expected END, got: %v", s.peekGet())
+		return s.errorf("malformed label syntax, expected END, got: %v", s.peekGet())
 	}
 	return nil
 }
 
-// a parses type a commands, syntax @(symbol|address)
+// directive parses directives, syntax .name arg, ... or for constants
+// .equ name value
+func (s *state) directive() (command.Any, error) {
+	t := s.peekGet()
+	s.acceptAny()
+	rest := strings.TrimLeftFunc(t.Value[1:], unicode.IsLetter)
+	d := command.Directive{Name: t.Value[1 : len(t.Value)-len(rest)], Pos: t.Pos}
+	if !s.peek(token.END) {
+		return nil, s.errorf("malformed directive, expected END got: %v", s.peekGet())
+	}
+	if d.Name == "equ" || d.Name == "define" {
+		return equ(t, d.Name, rest)
+	}
+	if args := strings.TrimSpace(rest); args != "" {
+		for _, a := range strings.Split(args, ",") {
+			a = strings.TrimSpace(a)
+			if a == "" {
+				return nil, diag.Errorf(t.Pos, diag.Syntax, "empty argument in: %s", t.Value)
+			}
+			d.Args = append(d.Args, a)
+		}
+	}
+	return d, nil
+}
+
+// equ parses the rest of a constant definition, syntax name[,] value
+func equ(t token.Token, directive, rest string) (command.Equ, error) {
+	args := strings.TrimLeftFunc(rest, unicode.IsSpace)
+	pos := t.Pos
+	pos.Col += len(t.Value) - len(args)
+	i := strings.IndexFunc(args, func(r rune) bool { return r == ',' || unicode.IsSpace(r) })
+	if i <= 0 {
+		return command.Equ{}, diag.Errorf(t.Pos, diag.Syntax, "expected .%s NAME VALUE, got: %s", directive, t.Value)
+	}
+	name := args[:i]
+	value := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(args[i:]), ","))
+	if unicode.IsDigit(rune(name[0])) || strings.ContainsAny(name, "()@=;") {
+		return command.Equ{}, diag.Errorf(pos, diag.Syntax, "bad constant name %s", name)
+	}
+	n, err := expr.Literal(value)
+	if errors.Is(err, strconv.ErrRange) || err == nil && (n < 0 || n > command.MaxAddress) {
+		return command.Equ{}, diag.Errorf(pos, diag.BadAddress, "constant %s value %s out of range, must be 0..%d", name, value, command.MaxAddress)
+	}
+	if err != nil {
+		return command.Equ{}, diag.Errorf(pos, diag.Syntax, "bad value for constant %s: %s", name, value)
+	}
+	equ := command.Equ{Directive: directive, Name: name, Value: n, Pos: pos}
+	if _, err := strconv.Atoi(value); err != nil {
+		equ.Text = value
+	}
+	return equ, nil
+}
+
+// a parses type a commands, syntax @(symbol|address|expression)
 func (s *state) a() error {
+	pos := s.peekGet().Pos
 	err := s.accept(token.AT)
 	if err != nil {
 		return err
@@ -101,42 +176,61 @@ func (s *state) a() error {
 		if err != nil {
 			return err
 		}
-		i, err := strconv.Atoi(s.tokens[s.index].Value)
+		v := s.tokens[s.index].Value
+		i, err := expr.Literal(v)
+		if errors.Is(err, strconv.ErrRange) || (err == nil && i > command.MaxAddress) {
+			return diag.Errorf(s.tokens[s.index].Pos, diag.BadAddress, "address %s out of range, must be 0..%d", v, command.MaxAddress)
+		}
 		if err != nil {
-			return fmt.Errorf("unexpected error parsing address: %v", err)
+			return diag.Errorf(s.tokens[s.index].Pos, diag.Syntax, "%v", err)
+		}
+		s.cmdA = command.A{Address: i, Static: true, Pos: pos}
+		if _, err := strconv.Atoi(v); err != nil {
+			s.cmdA.Text = v
 		}
-		s.cmdA = command.A{Address: i, Static: true}
 	} else if s.peek(token.SYMBOL) {
 		err := s.accept(token.SYMBOL)
 		if err != nil {
 			return err
 		}
-		s.cmdA = command.A{Symbol: s.tokens[s.index].Value}
+		s.cmdA = command.A{Symbol: s.tokens[s.index].Value, Pos: pos}
+	} else if s.peek(token.EXPR) {
+		t := s.peekGet()
+		s.acceptAny()
+		e, err := expr.Parse(t.Value)
+		if err != nil {
+			at := t.Pos
+			if e, ok := err.(*expr.Error); ok {
+				at.Col += e.Offset
+			}
+			return diag.Errorf(at, diag.Syntax, "%v", err)
+		}
+		s.cmdA = command.A{Expr: e, Pos: pos}
 	}
 	if !s.peek(token.END) {
-		return fmt.Errorf("malforned address syntax (@xxx), expected END got: %v", s.peekGet())
+		return s.errorf("malformed address syntax (@xxx), expected END got: %v", s.peekGet())
 	}
 	return nil
 }
 
 // c parses type c commands, syntax (dest=)comp(;jump)
 func (s *state) c() error {
-	if s.peek(token.OPERATOR) || s.peek(token.NUMBER) {
+	if s.peek(token.OPERATOR) || s.peek(token.NUMBER) || s.peek(token.RAWCOMP) {
 		err := s.comp()
 		if err != nil {
-			return fmt.Errorf("error calling comp() for operator/number: %v", err)
+			return err
 		}
 	} else if s.peek(token.LOCATION) {
 		// maybe this is comp part, or maybe this is dest part
 		if s.peekRange(token.ASSIGN, 2, 3) {
 			err := s.dest()
 			if err != nil {
-				return fmt.Errorf("error calling dest(): %v", err)
+				return err
 			}
 		} else {
 			err := s.comp()
 			if err != nil {
-				return fmt.Errorf("error calling comp() for LOCATION: %v", err)
+				return err
 			}
 		}
 	} else if s.peek(token.JUMP) {
@@ -152,17 +246,26 @@ func (s *state) c() error {
 
 // comp is the comp part of C command
 func (s *state) comp() error {
+	if s.peek(token.RAWCOMP) {
+		// control bits stand alone, syntax %bbbbbbb or alu(flag=bit,...)
+		if s.cmdC.C != "" {
+			return s.errorf("unexpected token: %v", s.peekGet())
+		}
+		s.acceptAny()
+		s.cmdC.C = s.tokens[s.index].Value
+		return s.c()
+	}
 	if s.peek(token.LOCATION) || s.peek(token.OPERATOR) || s.peek(token.NUMBER) {
 		s.acceptAny()
 		s.cmdC.C += s.tokens[s.index].Value
 		err := s.comp()
 		if err != nil {
-			return fmt.Errorf("error calling comp(): %v", err)
+			return err
 		}
 	} else {
 		err := s.c()
 		if err != nil {
-			return fmt.Errorf("error calling c(): %v", err)
+			return err
 		}
 	}
 	return nil
@@ -175,16 +278,21 @@ func (s *state) dest() error {
 		if err != nil {
 			return err
 		}
+		var dest *bool
 		switch s.tokens[s.index].Value {
 		case "A":
-			s.cmdC.D.A = true
+			dest = &s.cmdC.D.A
 		case "D":
-			s.cmdC.D.D = true
+			dest = &s.cmdC.D.D
 		case "M":
-			s.cmdC.D.M = true
+			dest = &s.cmdC.D.M
 		default:
-			return fmt.Errorf("unexpected value, expected A/D/M got: %v", s.tokens[s.index].Value)
+			return diag.Errorf(s.tokens[s.index].Pos, diag.BadDest, "unexpected value, expected A/D/M got: %v", s.tokens[s.index].Value)
+		}
+		if *dest {
+			return diag.Errorf(s.tokens[s.index].Pos, diag.BadDest, "duplicate destination %v", s.tokens[s.index].Value)
 		}
+		*dest = true
 	}
 	if s.peek(token.ASSIGN) {
 		err := s.accept(token.ASSIGN)
@@ -193,15 +301,15 @@ func (s *state) dest() error {
 		}
 		err = s.comp()
 		if err != nil {
-			return fmt.Errorf("error calling comp() for ASSIGN: %v", err)
+			return err
 		}
 	} else if s.peek(token.LOCATION) {
 		err := s.dest()
 		if err != nil {
-			return fmt.Errorf("dest(): %v", err)
+			return err
 		}
 	} else {
-		return fmt.Errorf("unexpected token, expected ASSIGN or LOCATION but got: %v", s.peekGet())
+		return s.errorf("unexpected token, expected ASSIGN or LOCATION but got: %v", s.peekGet())
 	}
 	return nil
 }
@@ -232,12 +340,27 @@ func (s *state) peekRange(t token.Type, offset int, count int) bool {
 // accept next token of type t
 func (s *state) accept(t token.Type) error {
 	if !s.peek(t) {
-		return fmt.Errorf("wrong token type, accept %v but got: %v", t, s.peekGet())
+		return s.errorf("wrong token type, accept %v but got: %v", t, s.peekGet())
 	}
 	s.acceptAny()
 	return nil
 }
 
+// errorf returns a syntax error positioned at the next token
+func (s *state) errorf(format string, a ...interface{}) error {
+	return diag.Errorf(s.peekGet().Pos, diag.Syntax, format, a...)
+}
+
+// skip past the END of the current statement, recovering from an error
+func (s *state) skip() {
+	for !s.end() && !s.peek(token.END) {
+		s.acceptAny()
+	}
+	if !s.end() {
+		s.acceptAny()
+	}
+}
+
 // end returns true if we have accepted the last token already
 func (s *state) end() bool {
 	return s.index >= len(s.tokens)-1
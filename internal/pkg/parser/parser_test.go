@@ -1,11 +1,14 @@
 package parser
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
@@ -27,7 +30,7 @@ func TestCommandTypeL(t *testing.T) {
 	}
 
 	for _, c := range testCases {
-		program, err := Parse(c.tokens)
+		program, err := Parse(c.tokens, nil)
 		assert.NoError(t, err)
 
 		assert.Equal(t, c.expected, program[0])
@@ -65,10 +68,26 @@ func TestCommandTypeA(t *testing.T) {
 			},
 			expected: command.A{Address: 0, Static: false, Symbol: "i"},
 		},
+		{
+			tokens: []token.Token{
+				{Type: token.AT, Value: "@"},
+				{Type: token.ADDRESS, Value: "0x4000"},
+				{Type: token.END},
+			},
+			expected: command.A{Address: 16384, Static: true, Text: "0x4000"},
+		},
+		{
+			tokens: []token.Token{
+				{Type: token.AT, Value: "@"},
+				{Type: token.ADDRESS, Value: "'\\n'"},
+				{Type: token.END},
+			},
+			expected: command.A{Address: 128, Static: true, Text: "'\\n'"},
+		},
 	}
 
 	for _, c := range testCases {
-		program, err := Parse(c.tokens)
+		program, err := Parse(c.tokens, nil)
 		assert.NoError(t, err)
 
 		assert.Equal(t, c.expected, program[0])
@@ -157,12 +176,189 @@ func TestCommandTypeC(t *testing.T) {
 				J: "",
 			},
 		},
+		{
+			// dest=raw comp;jump
+			tokens: []token.Token{
+				{Type: token.LOCATION, Value: "A"},
+				{Type: token.LOCATION, Value: "M"},
+				{Type: token.ASSIGN, Value: "="},
+				{Type: token.RAWCOMP, Value: "alu(zx=1,nx=1)"},
+				{Type: token.JUMP, Value: "JEQ"},
+				{Type: token.END},
+			},
+			expected: command.C{
+				D: command.Dest{D: false, A: true, M: true},
+				C: "alu(zx=1,nx=1)",
+				J: "JEQ",
+			},
+		},
+		{
+			// raw comp only
+			tokens: []token.Token{
+				{Type: token.RAWCOMP, Value: "%0101010"},
+				{Type: token.JUMP, Value: "JMP"},
+				{Type: token.END},
+			},
+			expected: command.C{
+				D: command.Dest{D: false, A: false, M: false},
+				C: "%0101010",
+				J: "JMP",
+			},
+		},
 	}
 
 	for _, c := range testCases {
-		program, err := Parse(c.tokens)
+		program, err := Parse(c.tokens, nil)
 		assert.NoError(t, err)
 
 		assert.Equal(t, c.expected, program[0])
 	}
 }
+
+func TestCommandPositions(t *testing.T) {
+	tokens := []token.Token{
+		{Type: token.LABEL, Value: "(", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.SYMBOL, Value: "LOOP", Pos: token.Pos{Line: 1, Col: 2}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 7}},
+		{Type: token.AT, Value: "@", Pos: token.Pos{Line: 2, Col: 3}},
+		{Type: token.SYMBOL, Value: "LOOP", Pos: token.Pos{Line: 2, Col: 4}},
+		{Type: token.END, Pos: token.Pos{Line: 2, Col: 8}},
+		{Type: token.NUMBER, Value: "0", Pos: token.Pos{Line: 3, Col: 3}},
+		{Type: token.JUMP, Value: "JMP", Pos: token.Pos{Line: 3, Col: 5}},
+		{Type: token.END, Pos: token.Pos{Line: 3, Col: 8}},
+	}
+
+	program, err := Parse(tokens, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, command.Program{
+		command.L{Symbol: "LOOP", Pos: token.Pos{Line: 1, Col: 1}},
+		command.A{Symbol: "LOOP", Pos: token.Pos{Line: 2, Col: 3}},
+		command.C{C: "0", J: "JMP", Pos: token.Pos{Line: 3, Col: 3}},
+	}, program)
+
+	_, err = Parse([]token.Token{
+		{Type: token.AT, Value: "@", Pos: token.Pos{File: "f.asm", Line: 4, Col: 1}},
+		{Type: token.SYMBOL, Value: "x", Pos: token.Pos{File: "f.asm", Line: 4, Col: 2}},
+		{Type: token.SYMBOL, Value: "y", Pos: token.Pos{File: "f.asm", Line: 4, Col: 3}},
+		{Type: token.END, Pos: token.Pos{File: "f.asm", Line: 4, Col: 4}},
+	}, nil)
+	assert.EqualError(t, err, `f.asm:4:3: error[E001]: malformed address syntax (@xxx), expected END got: SYMBOL "y"`)
+}
+
+func TestRecoverAfterError(t *testing.T) {
+	var diags diag.List
+	program, err := Parse([]token.Token{
+		{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.SYMBOL, Value: "x", Pos: token.Pos{Line: 1, Col: 2}},
+		{Type: token.SYMBOL, Value: "y", Pos: token.Pos{Line: 1, Col: 3}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 4}},
+		{Type: token.LOCATION, Value: "D", Pos: token.Pos{Line: 2, Col: 1}},
+		{Type: token.END, Pos: token.Pos{Line: 2, Col: 2}},
+		{Type: token.LABEL, Value: "(", Pos: token.Pos{Line: 3, Col: 1}},
+		{Type: token.SYMBOL, Value: "a", Pos: token.Pos{Line: 3, Col: 2}},
+		{Type: token.SYMBOL, Value: "b", Pos: token.Pos{Line: 3, Col: 3}},
+		{Type: token.END, Pos: token.Pos{Line: 3, Col: 4}},
+		{Type: token.AT, Value: "@", Pos: token.Pos{Line: 4, Col: 1}},
+		{Type: token.ADDRESS, Value: "1", Pos: token.Pos{Line: 4, Col: 2}},
+		{Type: token.END, Pos: token.Pos{Line: 4, Col: 3}},
+	}, &diags)
+	assert.Error(t, err)
+	assert.Len(t, diags, 2)
+	assert.Equal(t, 1, diags[0].Pos.Line)
+	assert.Equal(t, 3, diags[1].Pos.Line)
+	assert.Len(t, program, 2)
+	assert.Equal(t, command.A{Address: 1, Static: true, Pos: token.Pos{Line: 4, Col: 1}}, program[1])
+}
+
+func TestDirective(t *testing.T) {
+	program, err := Parse([]token.Token{
+		{Type: token.DIRECTIVE, Value: ".import A,  B", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 14}},
+		{Type: token.DIRECTIVE, Value: ".export", Pos: token.Pos{Line: 2, Col: 1}},
+		{Type: token.END, Pos: token.Pos{Line: 2, Col: 8}},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, command.Program{
+		command.Directive{Name: "import", Args: []string{"A", "B"}, Pos: token.Pos{Line: 1, Col: 1}},
+		command.Directive{Name: "export", Pos: token.Pos{Line: 2, Col: 1}},
+	}, program)
+
+	_, err = Parse([]token.Token{
+		{Type: token.DIRECTIVE, Value: ".export A,,B", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 13}},
+	}, nil)
+	assert.EqualError(t, err, "1:1: error[E001]: empty argument in: .export A,,B")
+}
+
+func TestEqu(t *testing.T) {
+	testCases := map[string]command.Equ{
+		".equ WIDTH 32":      {Directive: "equ", Name: "WIDTH", Value: 32, Pos: token.Pos{Line: 1, Col: 6}},
+		".define  ROWS, 256": {Directive: "define", Name: "ROWS", Value: 256, Pos: token.Pos{Line: 1, Col: 10}},
+		".equ\tMAX,32767":    {Directive: "equ", Name: "MAX", Value: 32767, Pos: token.Pos{Line: 1, Col: 6}},
+		".equ A.b$c 0":       {Directive: "equ", Name: "A.b$c", Value: 0, Pos: token.Pos{Line: 1, Col: 6}},
+		".equ RAM 0x4000":    {Directive: "equ", Name: "RAM", Value: 16384, Text: "0x4000", Pos: token.Pos{Line: 1, Col: 6}},
+		".equ SPACE ' '":     {Directive: "equ", Name: "SPACE", Value: 32, Text: "' '", Pos: token.Pos{Line: 1, Col: 6}},
+	}
+	for src, expected := range testCases {
+		program, err := Parse([]token.Token{
+			{Type: token.DIRECTIVE, Value: src, Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.END, Pos: token.Pos{Line: 1, Col: len(src) + 1}},
+		}, nil)
+		assert.NoError(t, err, src)
+		assert.Equal(t, command.Program{expected}, program, src)
+	}
+
+	errors := map[string]string{
+		".equ":          "1:1: error[E001]: expected .equ NAME VALUE, got: .equ",
+		".equ WIDTH":    "1:1: error[E001]: expected .equ NAME VALUE, got: .equ WIDTH",
+		".equ 1X 2":     "1:6: error[E001]: bad constant name 1X",
+		".equ X Y":      "1:6: error[E001]: bad value for constant X: Y",
+		".define X 1 2": "1:9: error[E001]: bad value for constant X: 1 2",
+		".equ X 32768":  "1:6: error[E006]: constant X value 32768 out of range, must be 0..32767",
+		".equ X 0xFFFF": "1:6: error[E006]: constant X value 0xFFFF out of range, must be 0..32767",
+		".equ X 0b12":   "1:6: error[E001]: bad value for constant X: 0b12",
+	}
+	for src, expected := range errors {
+		_, err := Parse([]token.Token{
+			{Type: token.DIRECTIVE, Value: src, Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.END, Pos: token.Pos{Line: 1, Col: len(src) + 1}},
+		}, nil)
+		assert.EqualError(t, err, expected, src)
+	}
+}
+
+func TestCommandTypeAExpr(t *testing.T) {
+	program, err := Parse([]token.Token{
+		{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.EXPR, Value: "END-1", Pos: token.Pos{Line: 1, Col: 2}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 7}},
+	}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, command.Program{
+		command.A{Expr: expr.Binary{Op: "-", X: expr.Sym{Name: "END"}, Y: expr.Num{Value: 1}}, Pos: token.Pos{Line: 1, Col: 1}},
+	}, program)
+
+	_, err = Parse([]token.Token{
+		{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+		{Type: token.EXPR, Value: "ROW*(32", Pos: token.Pos{Line: 1, Col: 2}},
+		{Type: token.END, Pos: token.Pos{Line: 1, Col: 9}},
+	}, nil)
+	assert.EqualError(t, err, "1:9: error[E001]: missing ) in expression ROW*(32")
+}
+
+func TestCommandTypeALiteralErrors(t *testing.T) {
+	testCases := map[string]string{
+		"0xZZ":                          "1:2: error[E001]: bad number 0xZZ",
+		"'ab'":                          "1:2: error[E001]: bad character 'ab', expected a printable ASCII character or escape",
+		"0x8000":                        "1:2: error[E006]: address 0x8000 out of range, must be 0..32767",
+		"0b1" + strings.Repeat("0", 64): "1:2: error[E006]: address 0b1" + strings.Repeat("0", 64) + " out of range, must be 0..32767",
+	}
+	for value, expected := range testCases {
+		_, err := Parse([]token.Token{
+			{Type: token.AT, Value: "@", Pos: token.Pos{Line: 1, Col: 1}},
+			{Type: token.ADDRESS, Value: value, Pos: token.Pos{Line: 1, Col: 2}},
+			{Type: token.END, Pos: token.Pos{Line: 1, Col: 2 + len(value)}},
+		}, nil)
+		assert.EqualError(t, err, expected, value)
+	}
+}
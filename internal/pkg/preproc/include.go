@@ -0,0 +1,77 @@
+package preproc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// include expands the file named by an .include directive, s, at pos
+func (e *expander) include(s string, pos token.Pos) {
+	arg := strings.TrimSpace(strings.TrimPrefix(s, ".include"))
+	path, err := strconv.Unquote(arg)
+	if err != nil || !strings.HasPrefix(arg, `"`) || path == "" {
+		e.diags.Add(diag.Errorf(pos, diag.Include, `expected .include "file", got: %s`, s))
+		return
+	}
+	name, r, err := e.opts.find(path, filepath.Dir(pos.File))
+	if err != nil {
+		e.diags.Add(diag.Errorf(pos, diag.Include, "%v", err))
+		return
+	}
+	defer r.Close()
+
+	for i, f := range e.files {
+		if key(f) == key(name) {
+			cycle := append(append([]string{}, e.files[i:]...), name)
+			e.diags.Add(diag.Errorf(pos, diag.Include, "include cycle: %s", strings.Join(cycle, " -> ")))
+			return
+		}
+	}
+	e.files = append(e.files, name)
+	if err := e.file(name, r, &pos); err != nil {
+		e.diags.Add(diag.Errorf(pos, diag.Include, "reading %s: %v", name, err))
+	}
+	e.files = e.files[:len(e.files)-1]
+}
+
+// find opens an included file, looking in dir and then each of the Path,
+// returning the name it was found at
+func (o Options) find(path, dir string) (string, io.ReadCloser, error) {
+	dirs := []string{dir}
+	if filepath.IsAbs(path) {
+		dirs = []string{""}
+	} else {
+		for _, d := range o.Path {
+			if filepath.Clean(d) != filepath.Clean(dir) {
+				dirs = append(dirs, d)
+			}
+		}
+	}
+	for _, d := range dirs {
+		name := filepath.Join(d, path)
+		r, err := o.open(name)
+		if err == nil {
+			return name, r, nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+	return "", nil, fmt.Errorf("cannot find %s in %s", path, strings.Join(dirs, ", "))
+}
+
+// key identifies a file for include cycle detection
+func key(name string) string {
+	if abs, err := filepath.Abs(name); err == nil {
+		return abs
+	}
+	return filepath.Clean(name)
+}
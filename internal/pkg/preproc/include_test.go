@@ -0,0 +1,97 @@
+package preproc
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// fs opens files from a map, for Options.Open
+type fs map[string]string
+
+func (f fs) open(name string) (io.ReadCloser, error) {
+	src, ok := f[filepath.ToSlash(name)]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return ioutil.NopCloser(strings.NewReader(src)), nil
+}
+
+// texts returns the text of expanded lines
+func texts(lines []Line) []string {
+	var s []string
+	for _, l := range lines {
+		s = append(s, l.Text)
+	}
+	return s
+}
+
+func TestInclude(t *testing.T) {
+	files := fs{
+		"src/lib/math.asm": "// math\n.include \"inc.asm\"\n",
+		"src/lib/inc.asm":  "M=M+1\n",
+		"lib/screen.asm":   "@SCREEN\n",
+	}
+	o := Options{Path: []string{"lib"}, Open: files.open}
+	lines, err := o.Expand([]File{{Name: "src/main.asm", R: strings.NewReader("@i\n  .include \"lib/math.asm\"\n.include \"screen.asm\"\n")}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"@i", "// math", "M=M+1", "@SCREEN"}, texts(lines))
+
+	// M=M+1 from inc.asm, included by math.asm, included by main.asm
+	main := token.Pos{File: "src/main.asm", Line: 2, Col: 3}
+	math := token.Pos{File: filepath.FromSlash("src/lib/math.asm"), Line: 2, Col: 1, Expansion: &main}
+	assert.Equal(t, token.Pos{File: filepath.FromSlash("src/lib/inc.asm"), Line: 1, Col: 1, Expansion: &math}, lines[2].Pos)
+	assert.Equal(t, main, lines[2].Pos.Site())
+	assert.Equal(t, filepath.FromSlash("lib/screen.asm"), lines[3].Pos.File)
+}
+
+func TestIncludeErrors(t *testing.T) {
+	files := fs{
+		"a.asm": ".include \"b.asm\"\n",
+		"b.asm": "@1\n.include \"a.asm\"\n",
+		"c.asm": "@1\n.include \"c.asm\"\n",
+	}
+	testCases := map[string]string{
+		".include \"a.asm\"\n": "b.asm:2:1: error[E011]: include cycle: a.asm -> b.asm -> a.asm (via a.asm:1:1, main.asm:1:1)",
+		".include \"c.asm\"\n": "c.asm:2:1: error[E011]: include cycle: c.asm -> c.asm (via main.asm:1:1)",
+		".include \"x.asm\"\n": "main.asm:1:1: error[E011]: cannot find x.asm in ., inc",
+		".include x.asm\n":     "main.asm:1:1: error[E011]: expected .include \"file\", got: .include x.asm",
+		".include\n":           "main.asm:1:1: error[E011]: expected .include \"file\", got: .include",
+	}
+	for src, expected := range testCases {
+		var diags diag.List
+		o := Options{Path: []string{"inc"}, Open: files.open}
+		_, err := o.Expand([]File{{Name: "main.asm", R: strings.NewReader(src)}}, &diags)
+		assert.EqualError(t, err, expected, src)
+	}
+}
+
+func TestIncludeInMacro(t *testing.T) {
+	files := fs{"body.asm": "D=D+1\n"}
+	src := ".macro INC\n  .include \"body.asm\"\n.endm\nINC\nINC\n"
+	lines, err := Options{Open: files.open}.Expand([]File{{Name: "m.asm", R: strings.NewReader(src)}}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"D=D+1", "D=D+1"}, texts(lines))
+	assert.Equal(t, "body.asm:1:1 (via m.asm:2:3, m.asm:5:1)", lines[1].Pos.String())
+}
+
+func TestExpandFiles(t *testing.T) {
+	// macros defined in one file may be called in later ones
+	files := []File{
+		{Name: "defs.asm", R: strings.NewReader(".macro ZERO x\n  @x\n  M=0\n.endm\n")},
+		{Name: "main.asm", R: strings.NewReader("ZERO i\n")},
+	}
+	lines, err := Options{}.Expand(files, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"  @i", "  M=0"}, texts(lines))
+	call := token.Pos{File: "main.asm", Line: 1, Col: 1}
+	assert.Equal(t, token.Pos{File: "defs.asm", Line: 2, Col: 1, Expansion: &call}, lines[0].Pos)
+}
@@ -0,0 +1,174 @@
+package preproc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Macro is a macro definition.
+type Macro struct {
+	Name   string
+	Params []string
+	Body   []Line
+	Pos    token.Pos
+	// labels defined in the body
+	labels map[string]bool
+}
+
+// define starts a macro from the fields of its .macro line, s. A macro with
+// problems is still read up to .endm, but left undefined.
+func (e *expander) define(fields []string, s string, pos token.Pos) *Macro {
+	m := &Macro{Pos: pos, labels: map[string]bool{}}
+	if len(fields) < 2 {
+		e.diags.Add(diag.Errorf(pos, diag.Macro, "missing macro name"))
+		return m
+	}
+	name := fields[1]
+	switch prev, ok := e.macros[name]; {
+	case !isSymbol(name) || reserved(name):
+		e.diags.Add(diag.Errorf(pos, diag.Macro, "bad macro name %s", name))
+		return m
+	case ok:
+		e.diags.Add(diag.Errorf(pos, diag.Macro, "macro %s already defined at %v", name, prev.Pos))
+		return m
+	}
+
+	rest := strings.TrimSpace(strings.TrimPrefix(s, ".macro"))
+	params := split(strings.TrimSpace(strings.TrimPrefix(rest, name)))
+	seen := map[string]bool{}
+	for _, p := range params {
+		switch {
+		case !isSymbol(p) || reserved(p):
+			e.diags.Add(diag.Errorf(pos, diag.Macro, "bad parameter name %q for macro %s", p, name))
+			return m
+		case seen[p]:
+			e.diags.Add(diag.Errorf(pos, diag.Macro, "duplicate parameter %s for macro %s", p, name))
+			return m
+		}
+		seen[p] = true
+	}
+	m.Name, m.Params = name, params
+	return m
+}
+
+// call expands a call of macro m on line l
+func (e *expander) call(m *Macro, l Line) {
+	call := l.Pos
+	call.Col += indent(l.Text)
+	if e.active[m.Name] {
+		e.diags.Add(diag.Errorf(call, diag.Macro, "macro %s calls itself", m.Name))
+		return
+	}
+	s := clean(l.Text)
+	args := split(strings.TrimSpace(s[len(m.Name):]))
+	if len(args) != len(m.Params) {
+		e.diags.Add(diag.Errorf(call, diag.Macro, "macro %s takes %d arguments, got %d", m.Name, len(m.Params), len(args)))
+		return
+	}
+	for _, a := range args {
+		if a == "" {
+			e.diags.Add(diag.Errorf(call, diag.Macro, "empty argument to macro %s", m.Name))
+			return
+		}
+	}
+
+	e.count++
+	replace := map[string]string{}
+	for lbl := range m.labels {
+		replace[lbl] = fmt.Sprintf("%s$%s.%d", lbl, m.Name, e.count)
+	}
+	for i, p := range m.Params {
+		replace[p] = args[i]
+	}
+
+	e.active[m.Name] = true
+	for _, b := range m.Body {
+		pos := b.Pos
+		pos.Expansion = &call
+		e.line(Line{Text: substitute(b.Text, replace), Pos: pos})
+	}
+	delete(e.active, m.Name)
+}
+
+// substitute replaces whole symbols in the code of s, leaving any comment
+func substitute(s string, replace map[string]string) string {
+	code, comment := s, ""
+	if i := strings.Index(s, "//"); i != -1 {
+		code, comment = s[:i], s[i:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(code); {
+		j := i
+		for j < len(code) && isSymbolByte(code[j]) {
+			j++
+		}
+		if j == i {
+			b.WriteByte(code[i])
+			i++
+			continue
+		}
+		word := code[i:j]
+		if r, ok := replace[word]; ok {
+			word = r
+		}
+		b.WriteString(word)
+		i = j
+	}
+	b.WriteString(comment)
+	return b.String()
+}
+
+// label returns the label a line defines, if any
+func label(s string) (string, bool) {
+	s = clean(s)
+	if len(s) < 2 || s[0] != '(' || s[len(s)-1] != ')' {
+		return "", false
+	}
+	return s[1 : len(s)-1], true
+}
+
+// split comma separated arguments or parameters, which may be empty
+func split(s string) []string {
+	if s == "" {
+		return nil
+	}
+	parts := strings.Split(s, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	return parts
+}
+
+// isSymbol returns true if s is a valid symbol name
+func isSymbol(s string) bool {
+	if s == "" || (s[0] >= '0' && s[0] <= '9') {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isSymbolByte(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// isSymbolByte returns true if c may appear in a symbol
+func isSymbolByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("_.$:", c) != -1
+}
+
+// reserved returns true for names which would replace part of a C
+// instruction: registers, destinations and jumps
+func reserved(s string) bool {
+	if strings.Trim(s, "ADM") == "" {
+		return true
+	}
+	switch s {
+	case "JGT", "JEQ", "JGE", "JLT", "JNE", "JLE", "JMP":
+		return true
+	}
+	return false
+}
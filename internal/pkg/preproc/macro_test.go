@@ -0,0 +1,127 @@
+package preproc
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+const progMacros = `// push D onto the stack
+.macro PUSH_D
+    @SP
+    A=M
+    M=D
+    @SP
+    M=M+1
+.endm
+
+.macro PUSH_CONST n
+    @n
+    D=A
+    PUSH_D        // nested call
+.endm
+
+.macro WAIT count
+    @count
+    D=A
+(LOOP)
+    D=D-1
+    @LOOP
+    D;JGT
+.endm
+
+    PUSH_CONST 7
+    WAIT 3
+    WAIT 5
+`
+
+const progExpanded = `
+    @7
+    D=A
+    @SP
+    A=M
+    M=D
+    @SP
+    M=M+1
+    @3
+    D=A
+(LOOP$WAIT.3)
+    D=D-1
+    @LOOP$WAIT.3
+    D;JGT
+    @5
+    D=A
+(LOOP$WAIT.4)
+    D=D-1
+    @LOOP$WAIT.4
+    D;JGT
+`
+
+// commands formats a program's commands without positions
+func commands(t *testing.T, tokens []token.Token) []string {
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	var s []string
+	for _, c := range program {
+		s = append(s, fmt.Sprint(c))
+	}
+	return s
+}
+
+func TestExpand(t *testing.T) {
+	tokens, err := TokenizeFile("prog.asm", strings.NewReader(progMacros), nil)
+	assert.NoError(t, err)
+	expected, err := lex.Tokenize(strings.NewReader(progExpanded))
+	assert.NoError(t, err)
+	assert.Equal(t, commands(t, expected), commands(t, tokens))
+}
+
+func TestExpandPositions(t *testing.T) {
+	tokens, err := TokenizeFile("prog.asm", strings.NewReader(progMacros), nil)
+	assert.NoError(t, err)
+
+	// @SP from PUSH_D, called by PUSH_CONST
+	call := token.Pos{File: "prog.asm", Line: 25, Col: 5}
+	nested := token.Pos{File: "prog.asm", Line: 13, Col: 5, Expansion: &call}
+	at := token.Pos{File: "prog.asm", Line: 3, Col: 5, Expansion: &nested}
+	assert.Equal(t, token.Token{Type: token.AT, Value: "@", Pos: at}, tokens[7])
+	assert.Equal(t, "prog.asm:3:5 (via prog.asm:13:5, prog.asm:25:5)", tokens[7].Pos.String())
+	assert.Equal(t, call, tokens[7].Pos.Site())
+}
+
+func TestNoMacros(t *testing.T) {
+	src := "// sum\n  @i\r\nM=1\n(LOOP)\n@LOOP\n0;JMP\n"
+	expected, err := lex.TokenizeFile("a.asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	tokens, err := TokenizeFile("a.asm", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, tokens)
+}
+
+func TestExpandErrors(t *testing.T) {
+	testCases := map[string]string{
+		".macro SET v\n    M=v\n.endm\n    SET 2\n": "2:7: error[E001]: unexpected rune '2' in: M=2 (via 4:5)",
+		".macro F\n  F\n.endm\nF\n":                 "2:3: error[E010]: macro F calls itself (via 4:1)",
+		".macro F a, b\n.endm\nF 1\n":               "3:1: error[E010]: macro F takes 2 arguments, got 1",
+		".macro F a\n.endm\nF 1,\n":                 "3:1: error[E010]: macro F takes 1 arguments, got 2",
+		".macro F a, a\n.endm\n":                    "1:1: error[E010]: duplicate parameter a for macro F",
+		".macro F D\n.endm\n":                       "1:1: error[E010]: bad parameter name \"D\" for macro F",
+		".macro\n.endm\n":                           "1:1: error[E010]: missing macro name",
+		".macro F\n.endm\n.macro F\n.endm\n":        "3:1: error[E010]: macro F already defined at 1:1",
+		"  .macro F\n@1\n":                          "1:3: error[E010]: missing .endm for macro F",
+		".endm\n":                                   "1:1: error[E010]: .endm without .macro",
+		".macro F\n.macro G\n.endm\n":               "2:1: error[E010]: macro G defined inside macro F",
+	}
+	for src, expected := range testCases {
+		var diags diag.List
+		_, err := TokenizeFile("", strings.NewReader(src), &diags)
+		assert.EqualError(t, err, expected, src)
+	}
+}
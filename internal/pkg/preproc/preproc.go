@@ -0,0 +1,209 @@
+// Package preproc expands includes and macros in Hack assembly before it is
+// tokenized.
+//
+// A file is included with
+//
+//	.include "math.asm"
+//
+// found relative to the including file, else in the Options Path.
+//
+// A macro is defined with
+//
+//	.macro NAME param, ...
+//	    body
+//	.endm
+//
+// and called by its name on a line of its own, NAME arg, .... Parameters are
+// replaced by the arguments wherever they appear as a whole symbol in the
+// body. Labels defined in the body are local, renamed LABEL$NAME.n so every
+// expansion gets its own. Bodies may call other macros, but not define them.
+//
+// Lines from macro bodies and included files keep their own positions, with
+// the call or .include they came from as the position's Expansion.
+package preproc
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// Line is one line of expanded source and where it came from.
+type Line struct {
+	Text string
+	Pos  token.Pos
+}
+
+// File is a source file to expand, named Name in positions.
+type File struct {
+	Name string
+	R    io.Reader
+}
+
+// Options change how sources are expanded.
+type Options struct {
+	// Path lists directories searched for included files which aren't found
+	// relative to the including file.
+	Path []string
+	// Open opens included files, os.Open if nil.
+	Open func(name string) (io.ReadCloser, error)
+}
+
+// expander holds the macros defined so far while expanding a program
+type expander struct {
+	opts   Options
+	macros map[string]*Macro
+	// active are the macros being expanded, to catch recursion
+	active map[string]bool
+	// files are the names of the files being read, outermost first, to catch
+	// include cycles
+	files []string
+	// count numbers expansions, making local labels unique
+	count int
+	out   []Line
+	diags *diag.List
+}
+
+// Expand the includes and macros in r with default Options, see
+// Options.Expand.
+func Expand(name string, r io.Reader, diags *diag.List) ([]Line, error) {
+	return Options{}.Expand([]File{{Name: name, R: r}}, diags)
+}
+
+// TokenizeFile expands r with default Options and tokenizes the result, like
+// lex.TokenizeFile.
+func TokenizeFile(name string, r io.Reader, diags *diag.List) ([]token.Token, error) {
+	return Options{}.Tokenize([]File{{Name: name, R: r}}, diags)
+}
+
+// Expand files in order as one program, so macros defined in one file may be
+// called in later ones. Definitions are removed, calls replaced by their
+// bodies and includes by the included file. Problems are reported to diags,
+// the returned error is non-nil if diags holds any errors.
+func (o Options) Expand(files []File, diags *diag.List) ([]Line, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	e := &expander{opts: o, macros: map[string]*Macro{}, active: map[string]bool{}, diags: diags}
+	for _, f := range files {
+		e.files = []string{f.Name}
+		if err := e.file(f.Name, f.R, nil); err != nil {
+			return e.out, err
+		}
+	}
+	return e.out, diags.Err()
+}
+
+// Tokenize expands files and tokenizes the result, like lex.TokenizeFile.
+func (o Options) Tokenize(files []File, diags *diag.List) ([]token.Token, error) {
+	if diags == nil {
+		diags = &diag.List{}
+	}
+	lines, err := o.Expand(files, diags)
+	if _, ok := err.(diag.List); err != nil && !ok {
+		return nil, err
+	}
+	var result []token.Token
+	l := lex.New()
+	for _, line := range lines {
+		tokens, err := l.TokenizeLine(line.Text, line.Pos)
+		if err != nil {
+			diags.Add(err)
+			continue
+		}
+		result = append(result, tokens...)
+	}
+	return result, diags.Err()
+}
+
+// file expands one file, included at from if not nil. Errors reading r are
+// returned, all others reported to diags.
+func (e *expander) file(name string, r io.Reader, from *token.Pos) error {
+	var def *Macro
+	scanner := bufio.NewScanner(r)
+	pos := token.Pos{File: name, Col: 1, Expansion: from}
+	for scanner.Scan() {
+		pos.Line++
+		text := scanner.Text()
+		fields := strings.Fields(clean(text))
+		at := pos
+		at.Col += indent(text)
+
+		switch {
+		case len(fields) > 0 && fields[0] == ".macro":
+			if def != nil {
+				e.diags.Add(diag.Errorf(at, diag.Macro, "macro %s defined inside macro %s", strings.Join(fields[1:2], ""), def.Name))
+				continue
+			}
+			def = e.define(fields, clean(text), at)
+		case len(fields) > 0 && fields[0] == ".endm":
+			if def == nil {
+				e.diags.Add(diag.Errorf(at, diag.Macro, ".endm without .macro"))
+				continue
+			}
+			if def.Name != "" {
+				e.macros[def.Name] = def
+			}
+			def = nil
+		case def != nil:
+			def.Body = append(def.Body, Line{Text: text, Pos: pos})
+			if l, ok := label(text); ok {
+				def.labels[l] = true
+			}
+		default:
+			e.line(Line{Text: text, Pos: pos})
+		}
+	}
+	if def != nil {
+		e.diags.Add(diag.Errorf(def.Pos, diag.Macro, "missing .endm for macro %s", def.Name))
+	}
+	return scanner.Err()
+}
+
+// line adds a line of source outside macro definitions, expanding includes
+// and macro calls
+func (e *expander) line(l Line) {
+	fields := strings.Fields(clean(l.Text))
+	if len(fields) == 0 {
+		e.out = append(e.out, l)
+		return
+	}
+	if fields[0] == ".include" {
+		pos := l.Pos
+		pos.Col += indent(l.Text)
+		e.include(clean(l.Text), pos)
+		return
+	}
+	if m, ok := e.macros[fields[0]]; ok {
+		e.call(m, l)
+		return
+	}
+	e.out = append(e.out, l)
+}
+
+// open is Options.Open, defaulting to os.Open
+func (o Options) open(name string) (io.ReadCloser, error) {
+	if o.Open != nil {
+		return o.Open(name)
+	}
+	return os.Open(name)
+}
+
+// clean strips any comment and surrounding whitespace from a line
+func clean(s string) string {
+	if i := strings.Index(s, "//"); i != -1 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s)
+}
+
+// indent returns the number of leading whitespace bytes in s
+func indent(s string) int {
+	return len(s) - len(strings.TrimLeftFunc(s, unicode.IsSpace))
+}
@@ -1,8 +1,24 @@
 package token
 
+import (
+	"fmt"
+	"strings"
+)
+
 type Token struct {
 	Value string
 	Type  Type
+	Pos   Pos
+	// Leading and Trailing trivia are only kept by a lossless lexer.
+	Leading  []Trivia
+	Trailing []Trivia
+}
+
+func (t Token) String() string {
+	if t.Value == "" {
+		return t.Type.String()
+	}
+	return fmt.Sprintf("%v %q", t.Type, t.Value)
 }
 
 type Type int
@@ -19,9 +35,143 @@ const (
 	SYMBOL
 	ADDRESS
 	LABEL
+	// RAWCOMP is a computation given as ALU control bits, %1010101 or alu(...)
+	RAWCOMP
+	// DIRECTIVE is a statement starting with a dot, such as .export, whose
+	// value is the whole statement
+	DIRECTIVE
+	// EXPR is an A instruction operand computed from numbers and symbols,
+	// such as SCREEN+32
+	EXPR
+	// RPAREN, SEMICOLON, MACRO and EOF only appear in lossless token streams
+	RPAREN
+	SEMICOLON
+	EOF
+	// MACRO is a line of a macro definition or a macro call, whose value is
+	// the whole statement, kept as written
+	MACRO
 )
 
+var typeNames = [...]string{
+	UNKNOWN:   "UNKNOWN",
+	LOCATION:  "LOCATION",
+	ASSIGN:    "ASSIGN",
+	OPERATOR:  "OPERATOR",
+	NUMBER:    "NUMBER",
+	JUMP:      "JUMP",
+	END:       "END",
+	AT:        "AT",
+	SYMBOL:    "SYMBOL",
+	ADDRESS:   "ADDRESS",
+	LABEL:     "LABEL",
+	RAWCOMP:   "RAWCOMP",
+	DIRECTIVE: "DIRECTIVE",
+	EXPR:      "EXPR",
+	RPAREN:    "RPAREN",
+	SEMICOLON: "SEMICOLON",
+	EOF:       "EOF",
+	MACRO:     "MACRO",
+}
+
+func (t Type) String() string {
+	if t < 0 || int(t) >= len(typeNames) {
+		return fmt.Sprintf("Type(%d)", int(t))
+	}
+	return typeNames[t]
+}
+
+// Pos is a position in source, lines and columns start at 1.
+type Pos struct {
+	File string `json:"file,omitempty"`
+	Line int    `json:"line"`
+	Col  int    `json:"col"`
+	// Expansion is the macro call or .include a position was expanded from,
+	// nil in the files given to the assembler.
+	Expansion *Pos `json:"expansion,omitempty"`
+}
+
+// String formats the position as file:line:col, omitting the file if unknown,
+// followed by any Trace.
+func (p Pos) String() string {
+	return p.at() + p.Trace()
+}
+
+// Trace formats the macro calls and includes a position was expanded from,
+// innermost first, as " (via file:line:col, ...)", or "" if there are none.
+func (p Pos) Trace() string {
+	if p.Expansion == nil {
+		return ""
+	}
+	var sites []string
+	for e := p.Expansion; e != nil; e = e.Expansion {
+		sites = append(sites, e.at())
+	}
+	return " (via " + strings.Join(sites, ", ") + ")"
+}
+
+// at formats file:line:col alone
+func (p Pos) at() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Col)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Col)
+}
+
+// Site returns the position in the file given to the assembler: the
+// outermost macro call or .include for expanded positions, else p itself.
+func (p Pos) Site() Pos {
+	for p.Expansion != nil {
+		p = *p.Expansion
+	}
+	return p
+}
+
 // Commonly used fixed token types
 var (
 	End = Token{Type: END}
 )
+
+// TriviaKind classifies source text which isn't part of a statement.
+type TriviaKind int
+
+const (
+	Whitespace TriviaKind = iota
+	Newline
+	Comment
+	// Skipped is a line which failed to tokenize
+	Skipped
+)
+
+var triviaNames = [...]string{
+	Whitespace: "Whitespace",
+	Newline:    "Newline",
+	Comment:    "Comment",
+	Skipped:    "Skipped",
+}
+
+func (k TriviaKind) String() string {
+	if k < 0 || int(k) >= len(triviaNames) {
+		return fmt.Sprintf("TriviaKind(%d)", int(k))
+	}
+	return triviaNames[k]
+}
+
+// Trivia is source text kept alongside tokens, so source can be printed
+// back exactly.
+type Trivia struct {
+	Kind TriviaKind
+	Text string
+}
+
+// Full returns the token's source text including its trivia.
+func (t Token) Full() string {
+	var b strings.Builder
+	for _, tr := range t.Leading {
+		b.WriteString(tr.Text)
+	}
+	b.WriteString(t.Value)
+	for _, tr := range t.Trailing {
+		b.WriteString(tr.Text)
+	}
+	return b.String()
+}
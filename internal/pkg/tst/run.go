@@ -0,0 +1,449 @@
+package tst
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cpu"
+)
+
+// Loader returns the program for a script's load command.
+type Loader func(path string) ([]uint16, error)
+
+// Mismatch is returned when output differs from the compare-to file.
+type Mismatch struct {
+	Line     int
+	Expected string
+	Got      string
+}
+
+func (m *Mismatch) Error() string {
+	return fmt.Sprintf("comparison failure at line %d\nexpected: %s\n     got: %s", m.Line, m.Expected, m.Got)
+}
+
+// DefaultMaxCycles is the Runner MaxCycles if not set.
+const DefaultMaxCycles = 10000000
+
+// Runner executes test scripts against a simulated Hack CPU, in the manner
+// of the nand2tetris CPUEmulator.
+type Runner struct {
+	Load Loader
+	// Echo receives the text of echo commands, if not nil
+	Echo io.Writer
+	// MaxCycles limits the cycles a repeat without a count, or a while, may
+	// run before the script fails, DefaultMaxCycles if 0
+	MaxCycles int
+
+	dir     string
+	name    string
+	cpu     *cpu.CPU
+	time    int
+	half    bool
+	cycles  int
+	columns []column
+	out     []string
+	outFile string
+	cmp     []string
+}
+
+// column of the output-list, e.g. RAM[0]%D2.6.2
+type column struct {
+	name             string
+	format           byte
+	padL, size, padR int
+}
+
+// RunFile runs the script at path, resolving file names relative to its
+// directory. The output file is written even if the comparison fails.
+func (r *Runner) RunFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	cmds, err := Parse(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	r.dir = filepath.Dir(path)
+	r.name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	r.cpu, _ = cpu.New(nil)
+	r.time, r.half, r.cycles = 0, false, 0
+	r.columns, r.out, r.outFile, r.cmp = nil, nil, "", nil
+	err = r.run(cmds)
+	if r.outFile != "" {
+		if werr := ioutil.WriteFile(r.outFile, []byte(strings.Join(r.out, "\n")+"\n"), 0644); err == nil {
+			err = werr
+		}
+	}
+	if _, ok := err.(*Mismatch); err != nil && !ok {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return err
+}
+
+// Output returns the output lines produced so far by the last RunFile.
+func (r *Runner) Output() []string {
+	return r.out
+}
+
+func (r *Runner) run(cmds []Command) error {
+	for _, c := range cmds {
+		if err := r.exec(c); err != nil {
+			if _, ok := err.(*Mismatch); ok {
+				return err
+			}
+			return fmt.Errorf("line %d: %s: %v", c.Line, c.Name, err)
+		}
+	}
+	return nil
+}
+
+func (r *Runner) exec(c Command) error {
+	switch c.Name {
+	case "load":
+		path := r.name + ".hack"
+		if len(c.Args) > 0 {
+			path = c.Args[0]
+		}
+		words, err := r.Load(r.path(path))
+		if err != nil {
+			return err
+		}
+		r.cpu, err = cpu.New(words)
+		return err
+	case "output-file":
+		if len(c.Args) != 1 {
+			return fmt.Errorf("expected a file name")
+		}
+		r.outFile = r.path(c.Args[0])
+	case "compare-to":
+		if len(c.Args) != 1 {
+			return fmt.Errorf("expected a file name")
+		}
+		b, err := ioutil.ReadFile(r.path(c.Args[0]))
+		if err != nil {
+			return err
+		}
+		r.cmp = strings.Split(strings.TrimRight(strings.Replace(string(b), "\r\n", "\n", -1), "\n"), "\n")
+	case "output-list":
+		r.columns = nil
+		for _, a := range c.Args {
+			col, err := parseColumn(a)
+			if err != nil {
+				return err
+			}
+			r.columns = append(r.columns, col)
+		}
+		return r.emit(r.header())
+	case "output":
+		line, err := r.row()
+		if err != nil {
+			return err
+		}
+		return r.emit(line)
+	case "set":
+		if len(c.Args) != 2 {
+			return fmt.Errorf("expected a variable and value")
+		}
+		v, err := parseValue(c.Args[1])
+		if err != nil {
+			return err
+		}
+		return r.set(c.Args[0], v)
+	case "repeat":
+		if !c.Block || len(c.Args) > 1 {
+			return fmt.Errorf("expected repeat [n] { ... }")
+		}
+		n := -1
+		if len(c.Args) == 1 {
+			v, err := strconv.Atoi(c.Args[0])
+			if err != nil {
+				return err
+			}
+			n = v
+		}
+		start := r.cycles
+		for i := 0; n < 0 || i < n; i++ {
+			if err := r.run(c.Body); err != nil {
+				return err
+			}
+			if n < 0 && r.cpu.Halted() {
+				break
+			}
+			if n < 0 {
+				if err := r.limit(start); err != nil {
+					return err
+				}
+			}
+		}
+	case "while":
+		if !c.Block || len(c.Args) != 3 {
+			return fmt.Errorf("expected while a op b { ... }")
+		}
+		start := r.cycles
+		for {
+			ok, err := r.cond(c.Args)
+			if err != nil || !ok {
+				return err
+			}
+			if err := r.run(c.Body); err != nil {
+				return err
+			}
+			if err := r.limit(start); err != nil {
+				return err
+			}
+		}
+	case "ticktock":
+		r.cpu.Step()
+		r.cycles++
+		r.time++
+		r.half = false
+	case "tick":
+		r.cpu.Step()
+		r.cycles++
+		r.half = true
+	case "tock":
+		if r.half {
+			r.time++
+			r.half = false
+		}
+	case "echo":
+		if r.Echo != nil {
+			fmt.Fprintln(r.Echo, strings.Trim(strings.Join(c.Args, " "), `"`))
+		}
+	case "clear-echo", "breakpoint", "clear-breakpoints":
+	default:
+		return fmt.Errorf("unknown command")
+	}
+	return nil
+}
+
+// limit returns an error once more than MaxCycles have run since start
+func (r *Runner) limit(start int) error {
+	max := r.MaxCycles
+	if max == 0 {
+		max = DefaultMaxCycles
+	}
+	if r.cycles-start > max {
+		return fmt.Errorf("still running after %d cycles, the program may never halt", max)
+	}
+	return nil
+}
+
+// emit an output line, comparing it against the compare-to file
+func (r *Runner) emit(line string) error {
+	r.out = append(r.out, line)
+	if r.cmp == nil {
+		return nil
+	}
+	n := len(r.out)
+	expected := ""
+	if n <= len(r.cmp) {
+		expected = r.cmp[n-1]
+	}
+	if strings.TrimRight(expected, " ") != strings.TrimRight(line, " ") {
+		return &Mismatch{Line: n, Expected: expected, Got: line}
+	}
+	return nil
+}
+
+func (r *Runner) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(r.dir, name)
+}
+
+func (r *Runner) header() string {
+	var b strings.Builder
+	b.WriteString("|")
+	for _, c := range r.columns {
+		width := c.padL + c.size + c.padR
+		name := c.name
+		if len(name) > width {
+			name = name[:width]
+		}
+		left := (width - len(name)) / 2
+		b.WriteString(strings.Repeat(" ", left) + name + strings.Repeat(" ", width-left-len(name)) + "|")
+	}
+	return b.String()
+}
+
+func (r *Runner) row() (string, error) {
+	var b strings.Builder
+	b.WriteString("|")
+	for _, c := range r.columns {
+		v, err := r.format(c)
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(strings.Repeat(" ", c.padL) + v + strings.Repeat(" ", c.padR) + "|")
+	}
+	return b.String(), nil
+}
+
+// format the current value of a column's variable
+func (r *Runner) format(c column) (string, error) {
+	if c.name == "time" {
+		s := strconv.Itoa(r.time)
+		if r.half {
+			s += "+"
+		}
+		return fit(s, c.size, false), nil
+	}
+	v, err := r.get(c.name)
+	if err != nil {
+		return "", err
+	}
+	switch c.format {
+	case 'X':
+		return fit(fmt.Sprintf("%0*X", c.size, v), c.size, true), nil
+	case 'B':
+		return fit(fmt.Sprintf("%0*b", c.size, v), c.size, true), nil
+	case 'S':
+		return fit(strconv.Itoa(int(int16(v))), c.size, false), nil
+	}
+	return fit(strconv.Itoa(int(int16(v))), c.size, true), nil
+}
+
+// fit s into size columns, right or left aligned, keeping the low digits
+func fit(s string, size int, right bool) string {
+	if len(s) > size {
+		return s[len(s)-size:]
+	}
+	pad := strings.Repeat(" ", size-len(s))
+	if right {
+		return pad + s
+	}
+	return s + pad
+}
+
+func (r *Runner) get(name string) (uint16, error) {
+	switch name {
+	case "A":
+		return r.cpu.A, nil
+	case "D":
+		return r.cpu.D, nil
+	case "PC":
+		return r.cpu.PC, nil
+	}
+	addr, err := ramAddress(name)
+	if err != nil {
+		return 0, err
+	}
+	return r.cpu.RAM[addr], nil
+}
+
+func (r *Runner) set(name string, v uint16) error {
+	switch name {
+	case "A":
+		r.cpu.A = v
+	case "D":
+		r.cpu.D = v
+	case "PC":
+		r.cpu.PC = v
+	default:
+		addr, err := ramAddress(name)
+		if err != nil {
+			return err
+		}
+		r.cpu.RAM[addr] = v
+	}
+	return nil
+}
+
+// cond evaluates a while condition: variable op value
+func (r *Runner) cond(args []string) (bool, error) {
+	a, err := r.get(args[0])
+	if err != nil {
+		return false, err
+	}
+	b, err := parseValue(args[2])
+	if err != nil {
+		return false, err
+	}
+	x, y := int16(a), int16(b)
+	switch args[1] {
+	case "=":
+		return x == y, nil
+	case "<>":
+		return x != y, nil
+	case "<":
+		return x < y, nil
+	case ">":
+		return x > y, nil
+	case "<=":
+		return x <= y, nil
+	case ">=":
+		return x >= y, nil
+	}
+	return false, fmt.Errorf("unknown operator %s", args[1])
+}
+
+// ramAddress parses RAM[n]
+func ramAddress(name string) (int, error) {
+	if !strings.HasPrefix(name, "RAM[") || !strings.HasSuffix(name, "]") {
+		return 0, fmt.Errorf("unknown variable %s", name)
+	}
+	addr, err := strconv.Atoi(name[4 : len(name)-1])
+	if err != nil || addr < 0 || addr >= cpu.RAMSize {
+		return 0, fmt.Errorf("bad RAM address %s", name)
+	}
+	return addr, nil
+}
+
+// parseValue parses a decimal value or one with a %D, %X or %B prefix
+func parseValue(s string) (uint16, error) {
+	base := 10
+	if len(s) > 2 && s[0] == '%' {
+		switch s[1] {
+		case 'X':
+			base = 16
+		case 'B':
+			base = 2
+		case 'D':
+		default:
+			return 0, fmt.Errorf("bad value %s", s)
+		}
+		s = s[2:]
+	}
+	v, err := strconv.ParseInt(s, base, 32)
+	if err != nil || v < -32768 || v > 65535 {
+		return 0, fmt.Errorf("bad value %s", s)
+	}
+	return uint16(v), nil
+}
+
+// parseColumn parses an output-list entry, name%Fpad.len.pad
+func parseColumn(s string) (column, error) {
+	c := column{name: s, format: 'D', padL: 1, size: 6, padR: 1}
+	i := strings.LastIndexByte(s, '%')
+	if i == -1 {
+		return c, nil
+	}
+	c.name = s[:i]
+	spec := s[i+1:]
+	if len(spec) < 1 || strings.IndexByte("DXBS", spec[0]) == -1 {
+		return c, fmt.Errorf("bad output format %s", s)
+	}
+	c.format = spec[0]
+	parts := strings.Split(spec[1:], ".")
+	if len(parts) != 3 {
+		return c, fmt.Errorf("bad output format %s", s)
+	}
+	for i, p := range []*int{&c.padL, &c.size, &c.padR} {
+		v, err := strconv.Atoi(parts[i])
+		if err != nil || v < 0 {
+			return c, fmt.Errorf("bad output format %s", s)
+		}
+		*p = v
+	}
+	return c, nil
+}
@@ -0,0 +1,119 @@
+package tst
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// add is RAM[2] = RAM[0] + RAM[1], then halt
+var add = []uint16{
+	0,                  // @R0
+	0b1111110000010000, // D=M
+	1,                  // @R1
+	0b1111000010010000, // D=D+M
+	2,                  // @R2
+	0b1110001100001000, // M=D
+	6,                  // (END) @END
+	0b1110101010000111, // 0;JMP
+}
+
+func write(t *testing.T, dir, name, content string) {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+}
+
+func TestRunFile(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "Add.tst", `load Add.hack,
+output-file Add.out,
+compare-to Add.cmp,
+output-list RAM[0]%D2.6.2 RAM[1]%D2.6.2 RAM[2]%D2.6.2 D%X1.4.1 PC%B1.4.1;
+set RAM[0] 2, set RAM[1] %X10;
+repeat 10 { ticktock; }
+output;
+set PC 0, set RAM[0] -20;
+while PC < 6 { ticktock; }
+output;
+echo "done";
+`)
+	write(t, dir, "Add.cmp", `|  RAM[0]  |  RAM[1]  |  RAM[2]  |  D   |  PC  |
+|       2  |      16  |      18  | 0012 | 0110 |
+|     -20  |      16  |      -4  | FFFC | 0110 |
+`)
+
+	var loaded string
+	var echo bytes.Buffer
+	r := &Runner{
+		Load: func(path string) ([]uint16, error) {
+			loaded = path
+			return add, nil
+		},
+		Echo: &echo,
+	}
+	assert.NoError(t, r.RunFile(filepath.Join(dir, "Add.tst")))
+	assert.Equal(t, filepath.Join(dir, "Add.hack"), loaded)
+	assert.Equal(t, "done\n", echo.String())
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "Add.out"))
+	assert.NoError(t, err)
+	cmp, _ := ioutil.ReadFile(filepath.Join(dir, "Add.cmp"))
+	assert.Equal(t, string(cmp), string(out))
+}
+
+func TestRunFileMismatch(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "Add.tst", "load, compare-to Add.cmp, output-list RAM[2]%D1.4.1;\nset RAM[0] 1, set RAM[1] 1; repeat 6 { ticktock; } output;\n")
+	write(t, dir, "Add.cmp", "|RAM[2]|\n|    3 |\n")
+
+	r := &Runner{Load: func(string) ([]uint16, error) { return add, nil }}
+	err := r.RunFile(filepath.Join(dir, "Add.tst"))
+	assert.Equal(t, &Mismatch{Line: 2, Expected: "|    3 |", Got: "|    2 |"}, err)
+	assert.Equal(t, []string{"|RAM[2]|", "|    2 |"}, r.Output())
+
+	write(t, dir, "Bad.tst", "load Add.hack, bogus 1;\n")
+	err = r.RunFile(filepath.Join(dir, "Bad.tst"))
+	assert.EqualError(t, err, filepath.Join(dir, "Bad.tst")+": line 1: bogus: unknown command")
+}
+
+func TestRunFileMaxCycles(t *testing.T) {
+	dir := t.TempDir()
+	// counts in D forever without halting
+	loop := []uint16{
+		0b1110011111010000, // D=D+1
+		0,                  // @0
+		0b1110101010000111, // 0;JMP
+	}
+	write(t, dir, "Loop.tst", "load Loop.hack;\nrepeat {\n  ticktock;\n}\n")
+	write(t, dir, "While.tst", "load Loop.hack;\nwhile PC < 3 { ticktock; }\n")
+
+	r := &Runner{Load: func(string) ([]uint16, error) { return loop, nil }, MaxCycles: 100}
+	err := r.RunFile(filepath.Join(dir, "Loop.tst"))
+	assert.EqualError(t, err, filepath.Join(dir, "Loop.tst")+": line 2: repeat: still running after 100 cycles, the program may never halt")
+	err = r.RunFile(filepath.Join(dir, "While.tst"))
+	assert.EqualError(t, err, filepath.Join(dir, "While.tst")+": line 2: while: still running after 100 cycles, the program may never halt")
+
+	// a program which halts is run to the end
+	r.Load = func(string) ([]uint16, error) { return add, nil }
+	assert.NoError(t, r.RunFile(filepath.Join(dir, "Loop.tst")))
+}
+
+func TestRunFileResets(t *testing.T) {
+	dir := t.TempDir()
+	write(t, dir, "Add.tst", "load, output-file Add.out, compare-to Add.cmp, output-list RAM[2]%D1.4.1;\nset RAM[0] 1, set RAM[1] 1; repeat 6 { ticktock; } output;\n")
+	write(t, dir, "Add.cmp", "|RAM[2]|\n|    2 |\n")
+	write(t, dir, "Set.tst", "load Add.hack, set RAM[0] 1;\n")
+
+	r := &Runner{Load: func(string) ([]uint16, error) { return add, nil }}
+	assert.NoError(t, r.RunFile(filepath.Join(dir, "Add.tst")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Add.out"), nil, 0644))
+
+	// the second script has no output file, compare file or output list
+	assert.NoError(t, r.RunFile(filepath.Join(dir, "Set.tst")))
+	assert.Empty(t, r.Output())
+	out, err := ioutil.ReadFile(filepath.Join(dir, "Add.out"))
+	assert.NoError(t, err)
+	assert.Empty(t, out)
+}
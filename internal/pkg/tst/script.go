@@ -0,0 +1,134 @@
+package tst
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"unicode"
+)
+
+// Command is one script command, e.g. set RAM[0] 3, with nested commands
+// for repeat and while blocks.
+type Command struct {
+	Name  string
+	Args  []string
+	Body  []Command
+	Line  int
+	Block bool
+}
+
+// word is a script token and the line it appeared on
+type word struct {
+	s    string
+	line int
+}
+
+// Parse a test script.
+func Parse(r io.Reader) ([]Command, error) {
+	src, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	words, err := split(string(src))
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{words: words}
+	cmds, err := p.commands()
+	if err != nil {
+		return nil, err
+	}
+	if p.i < len(p.words) {
+		return nil, fmt.Errorf("line %d: unexpected %q", p.words[p.i].line, p.words[p.i].s)
+	}
+	return cmds, nil
+}
+
+// split script source into words, punctuation and quoted strings, dropping
+// comments
+func split(src string) ([]word, error) {
+	var words []word
+	line := 1
+	for i := 0; i < len(src); {
+		ch := src[i]
+		switch {
+		case ch == '\n':
+			line++
+			i++
+		case unicode.IsSpace(rune(ch)):
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case ch == '"':
+			end := strings.IndexByte(src[i+1:], '"')
+			if end == -1 {
+				return nil, fmt.Errorf("line %d: unterminated string", line)
+			}
+			words = append(words, word{s: src[i : i+end+2], line: line})
+			i += end + 2
+		case strings.IndexByte(",;!{}", ch) != -1:
+			words = append(words, word{s: string(ch), line: line})
+			i++
+		default:
+			start := i
+			for i < len(src) && !unicode.IsSpace(rune(src[i])) && strings.IndexByte(",;!{}\"", src[i]) == -1 {
+				i++
+			}
+			words = append(words, word{s: src[start:i], line: line})
+		}
+	}
+	return words, nil
+}
+
+type scriptParser struct {
+	words []word
+	i     int
+}
+
+// commands parses until the end of input or a closing brace
+func (p *scriptParser) commands() ([]Command, error) {
+	var cmds []Command
+	for p.i < len(p.words) && p.words[p.i].s != "}" {
+		w := p.words[p.i]
+		if isTerminator(w.s) {
+			p.i++
+			continue
+		}
+		cmd := Command{Name: w.s, Line: w.line}
+		p.i++
+		for p.i < len(p.words) && !isTerminator(p.words[p.i].s) && p.words[p.i].s != "{" && p.words[p.i].s != "}" {
+			cmd.Args = append(cmd.Args, p.words[p.i].s)
+			p.i++
+		}
+		if p.i < len(p.words) && p.words[p.i].s == "{" {
+			p.i++
+			body, err := p.commands()
+			if err != nil {
+				return nil, err
+			}
+			if p.i >= len(p.words) {
+				return nil, fmt.Errorf("line %d: missing } for %s", w.line, w.s)
+			}
+			p.i++
+			cmd.Body, cmd.Block = body, true
+		} else if p.i >= len(p.words) || p.words[p.i].s == "}" {
+			return nil, fmt.Errorf("line %d: missing terminator after %s", w.line, w.s)
+		}
+		cmds = append(cmds, cmd)
+	}
+	return cmds, nil
+}
+
+func isTerminator(s string) bool {
+	return s == "," || s == ";" || s == "!"
+}
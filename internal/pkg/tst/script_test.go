@@ -0,0 +1,41 @@
+package tst
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	cmds, err := Parse(strings.NewReader(`// header
+load Max.hack,
+output-list RAM[0]%D2.6.2 /* inline */ RAM[1]%X1.4.1;
+echo "hello, world";
+repeat 3 {
+  ticktock;
+}
+output;
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []Command{
+		{Name: "load", Args: []string{"Max.hack"}, Line: 2},
+		{Name: "output-list", Args: []string{"RAM[0]%D2.6.2", "RAM[1]%X1.4.1"}, Line: 3},
+		{Name: "echo", Args: []string{`"hello, world"`}, Line: 4},
+		{Name: "repeat", Args: []string{"3"}, Body: []Command{{Name: "ticktock", Line: 6}}, Line: 5, Block: true},
+		{Name: "output", Line: 8},
+	}, cmds)
+}
+
+func TestParseErrors(t *testing.T) {
+	for src, expected := range map[string]string{
+		"repeat 3 { ticktock; ":   "line 1: missing } for repeat",
+		"repeat 3 { ticktock }":   "line 1: missing terminator after ticktock",
+		"output; }":               "line 1: unexpected \"}\"",
+		"/* never closed":         "line 1: unterminated comment",
+		"output;\necho \"oops;\n": "line 2: unterminated string",
+	} {
+		_, err := Parse(strings.NewReader(src))
+		assert.EqualError(t, err, expected, src)
+	}
+}
@@ -0,0 +1,197 @@
+package vet
+
+import (
+	"sort"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
+)
+
+// AKind is what is known about the A register before an instruction
+type AKind int
+
+const (
+	// Unknown values come from other blocks
+	Unknown AKind = iota
+	// Loaded values come from an A instruction
+	Loaded
+	// Computed values come from a C instruction with destination A
+	Computed
+)
+
+// AValue is the A register before an instruction. Symbol is the symbol of a
+// Loaded value, empty for a constant, Value its address and Pos where it was
+// loaded.
+type AValue struct {
+	Kind   AKind
+	Symbol string
+	Value  int
+	Pos    token.Pos
+}
+
+// Jump kinds of a C instruction
+type Jump int
+
+const (
+	NoJump Jump = iota
+	MayJump
+	Always
+)
+
+// Node is one instruction in the control flow graph.
+type Node struct {
+	Addr int
+	Cmd  command.Any
+	Pos  token.Pos
+	A    AValue
+	Jump Jump
+	// Succ are the addresses execution may continue at, len(Nodes) for
+	// running off the end of the program
+	Succ []int
+}
+
+// CFG is the control flow graph of a program, one node per ROM address.
+type CFG struct {
+	Nodes []*Node
+	// Labels are the label names at each address
+	Labels map[int][]string
+}
+
+// Build the control flow graph of an assembled program. A jump to an address
+// computed at run time may reach any label.
+func Build(program command.Program, symbols *assembler.SymbolTable) *CFG {
+	g := &CFG{Labels: map[int][]string{}}
+	for _, c := range program {
+		switch cmd := c.(type) {
+		case command.L:
+			if sym, ok := symbols.Lookup(cmd.Symbol); ok && sym.Kind == assembler.Label {
+				g.Labels[sym.Value] = append(g.Labels[sym.Value], cmd.Symbol)
+			}
+		case command.A:
+			g.Nodes = append(g.Nodes, &Node{Addr: len(g.Nodes), Cmd: cmd, Pos: cmd.Pos})
+		case command.C:
+			g.Nodes = append(g.Nodes, &Node{Addr: len(g.Nodes), Cmd: cmd, Pos: cmd.Pos})
+		}
+	}
+
+	// track A through straight line code, a label starts a new block
+	var a AValue
+	for _, n := range g.Nodes {
+		if _, ok := g.Labels[n.Addr]; ok {
+			a = AValue{}
+		}
+		n.A = a
+		switch cmd := n.Cmd.(type) {
+		case command.A:
+			a = AValue{Kind: Loaded, Symbol: cmd.Symbol, Value: cmd.Address, Pos: cmd.Pos}
+			if cmd.Expr != nil {
+				a.Value, _ = expr.Eval(cmd.Expr, func(name string) (int, bool) {
+					sym, ok := symbols.Lookup(name)
+					return sym.Value, ok
+				})
+			} else if sym, ok := symbols.Lookup(cmd.Symbol); ok && !cmd.Static {
+				a.Value = sym.Value
+			}
+		case command.C:
+			if cmd.D.A {
+				a = AValue{Kind: Computed, Pos: cmd.Pos}
+			}
+			n.Jump = jumpKind(cmd)
+		}
+	}
+
+	var labels []int
+	for addr := range g.Labels {
+		labels = append(labels, addr)
+	}
+	sort.Ints(labels)
+	for _, n := range g.Nodes {
+		if n.Jump != Always {
+			n.Succ = append(n.Succ, n.Addr+1)
+		}
+		if n.Jump == NoJump {
+			continue
+		}
+		if n.A.Kind == Loaded {
+			n.Succ = append(n.Succ, n.A.Value)
+			continue
+		}
+		n.Succ = append(n.Succ, labels...)
+	}
+	return g
+}
+
+// jumpKind decides whether a C instruction jumps, evaluating constant comps
+func jumpKind(cmd command.C) Jump {
+	if cmd.J == "" {
+		return NoJump
+	}
+	if cmd.J == "JMP" {
+		return Always
+	}
+	// raw control bits may spell a constant too
+	c := cmd.C
+	if bits, ok := assembler.CompBits(c); ok {
+		c, _ = assembler.CompMnemonic(bits)
+	}
+	v, ok := map[string]int{"0": 0, "1": 1, "-1": -1}[c]
+	if !ok {
+		return MayJump
+	}
+	var taken bool
+	switch cmd.J {
+	case "JGT":
+		taken = v > 0
+	case "JEQ":
+		taken = v == 0
+	case "JGE":
+		taken = v >= 0
+	case "JLT":
+		taken = v < 0
+	case "JNE":
+		taken = v != 0
+	case "JLE":
+		taken = v <= 0
+	}
+	if taken {
+		return Always
+	}
+	return NoJump
+}
+
+// Reachable returns which addresses execution can reach from address 0.
+// The extra last entry is true if execution can run off the end.
+func (g *CFG) Reachable() []bool {
+	seen := make([]bool, len(g.Nodes)+1)
+	if len(g.Nodes) == 0 {
+		return seen
+	}
+	work := []int{0}
+	seen[0] = true
+	for len(work) > 0 {
+		addr := work[len(work)-1]
+		work = work[:len(work)-1]
+		if addr >= len(g.Nodes) {
+			continue
+		}
+		for _, s := range g.Nodes[addr].Succ {
+			if s >= 0 && s < len(seen) && !seen[s] {
+				seen[s] = true
+				work = append(work, s)
+			}
+		}
+	}
+	return seen
+}
+
+// readsM returns true if a C instruction reads RAM[A]
+func readsM(cmd command.C) bool {
+	return assembler.ReadsM(cmd.C)
+}
+
+// usesA returns true if a C instruction uses A itself as a value
+func usesA(cmd command.C) bool {
+	return assembler.UsesA(cmd.C)
+}
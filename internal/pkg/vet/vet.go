@@ -0,0 +1,252 @@
+// Package vet finds likely mistakes in Hack assembly programs which assemble
+// without errors.
+package vet
+
+import (
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/expr"
+)
+
+// Check is one analysis, which reports warnings to a Pass.
+type Check struct {
+	Name string
+	Doc  string
+	Run  func(p *Pass)
+}
+
+// Pass holds a program being checked.
+type Pass struct {
+	Program command.Program
+	Symbols *assembler.SymbolTable
+	CFG     *CFG
+	diags   *diag.List
+}
+
+// Report a problem.
+func (p *Pass) Report(d diag.Diagnostic) {
+	p.diags.Add(d)
+}
+
+// Checks are all the checks, in the order they run.
+var Checks = []*Check{
+	UnusedLabel,
+	UnreadVar,
+	UninitVar,
+	Unreachable,
+	NoHalt,
+	LabelAsVar,
+	ComputedJump,
+}
+
+// Run checks on an assembled program, reporting to diags.
+func Run(program command.Program, symbols *assembler.SymbolTable, checks []*Check, diags *diag.List) {
+	p := &Pass{Program: program, Symbols: symbols, CFG: Build(program, symbols), diags: diags}
+	for _, c := range checks {
+		c.Run(p)
+	}
+}
+
+// UnusedLabel finds labels no A instruction refers to.
+var UnusedLabel = &Check{
+	Name: "unusedlabel",
+	Doc:  "labels which are never jumped to",
+	Run: func(p *Pass) {
+		used := map[string]bool{}
+		for _, c := range p.Program {
+			if cmd, ok := c.(command.A); ok && cmd.Expr != nil {
+				for _, s := range expr.Symbols(cmd.Expr) {
+					used[s.Name] = true
+				}
+			} else if ok && !cmd.Static {
+				used[cmd.Symbol] = true
+			}
+		}
+		for _, c := range p.Program {
+			if cmd, ok := c.(command.L); ok && !used[cmd.Symbol] {
+				p.Report(diag.Warnf(cmd.Pos, diag.UnusedLabel, "label %s is never used", cmd.Symbol))
+			}
+		}
+	},
+}
+
+// access is how a C instruction uses the variable in A
+type access struct {
+	node              *Node
+	read, write, addr bool
+}
+
+// accesses returns the uses of each variable by C instructions
+func (p *Pass) accesses() map[string][]access {
+	result := map[string][]access{}
+	for _, n := range p.CFG.Nodes {
+		cmd, ok := n.Cmd.(command.C)
+		if !ok || n.A.Kind != Loaded || n.A.Symbol == "" {
+			continue
+		}
+		if sym, ok := p.Symbols.Lookup(n.A.Symbol); !ok || sym.Kind != assembler.Variable {
+			continue
+		}
+		a := access{node: n, read: readsM(cmd), write: cmd.D.M, addr: usesA(cmd)}
+		result[n.A.Symbol] = append(result[n.A.Symbol], a)
+	}
+	return result
+}
+
+// UnreadVar finds variables which are stored to but never loaded.
+var UnreadVar = &Check{
+	Name: "unreadvar",
+	Doc:  "variables written but never read",
+	Run: func(p *Pass) {
+		uses := p.accesses()
+		for _, sym := range p.Symbols.Symbols() {
+			if sym.Kind != assembler.Variable {
+				continue
+			}
+			written, read := false, false
+			for _, a := range uses[sym.Name] {
+				written = written || a.write
+				read = read || a.read || a.addr
+			}
+			if written && !read {
+				p.Report(diag.Warnf(*sym.Pos, diag.UnreadVar, "variable %s is written but never read", sym.Name))
+			}
+		}
+	},
+}
+
+// UninitVar finds variables which may be read on some path before anything
+// is written to them. Variables whose address is used are assumed written.
+var UninitVar = &Check{
+	Name: "uninitvar",
+	Doc:  "variables read before any write",
+	Run: func(p *Pass) {
+		g := p.CFG
+		uses := p.accesses()
+		index := map[string]int{}
+		for name, as := range uses {
+			addr := false
+			for _, a := range as {
+				addr = addr || a.addr
+			}
+			if !addr {
+				index[name] = len(index)
+			}
+		}
+		if len(index) == 0 || len(g.Nodes) == 0 {
+			return
+		}
+
+		// in[n] is the set of variables written on every path to n, nil
+		// until a path reaches n
+		in := make([][]bool, len(g.Nodes))
+		in[0] = make([]bool, len(index))
+		work := []int{0}
+		for len(work) > 0 {
+			n := g.Nodes[work[len(work)-1]]
+			work = work[:len(work)-1]
+			out := append([]bool(nil), in[n.Addr]...)
+			if i, ok := index[n.A.Symbol]; ok && n.A.Kind == Loaded {
+				if cmd, ok := n.Cmd.(command.C); ok && cmd.D.M {
+					out[i] = true
+				}
+			}
+			for _, s := range n.Succ {
+				if s >= len(g.Nodes) {
+					continue
+				}
+				if in[s] == nil {
+					in[s] = append([]bool(nil), out...)
+					work = append(work, s)
+					continue
+				}
+				changed := false
+				for i := range out {
+					if in[s][i] && !out[i] {
+						in[s][i] = false
+						changed = true
+					}
+				}
+				if changed {
+					work = append(work, s)
+				}
+			}
+		}
+
+		reported := map[string]bool{}
+		for _, n := range g.Nodes {
+			cmd, ok := n.Cmd.(command.C)
+			i, tracked := index[n.A.Symbol]
+			if !ok || !tracked || n.A.Kind != Loaded || in[n.Addr] == nil || !readsM(cmd) {
+				continue
+			}
+			if !in[n.Addr][i] && !reported[n.A.Symbol] {
+				reported[n.A.Symbol] = true
+				p.Report(diag.Warnf(n.A.Pos, diag.UninitVar, "variable %s may be read before it is written", n.A.Symbol))
+			}
+		}
+	},
+}
+
+// Unreachable finds instructions execution can never reach.
+var Unreachable = &Check{
+	Name: "unreachable",
+	Doc:  "unreachable code, such as after an unconditional jump",
+	Run: func(p *Pass) {
+		reachable := p.CFG.Reachable()
+		for _, n := range p.CFG.Nodes {
+			if !reachable[n.Addr] && (n.Addr == 0 || reachable[n.Addr-1]) {
+				p.Report(diag.Warnf(n.Pos, diag.Unreachable, "unreachable code"))
+			}
+		}
+	},
+}
+
+// NoHalt finds programs which can run past their last instruction.
+var NoHalt = &Check{
+	Name: "nohalt",
+	Doc:  "programs without a terminating infinite loop",
+	Run: func(p *Pass) {
+		nodes := p.CFG.Nodes
+		if len(nodes) > 0 && p.CFG.Reachable()[len(nodes)] {
+			last := nodes[len(nodes)-1]
+			p.Report(diag.Warnf(last.Pos, diag.NoHalt, "execution can run past the end of the program, end it with a loop such as (END) @END 0;JMP"))
+		}
+	},
+}
+
+// LabelAsVar finds ROM addresses used as RAM addresses, as the assembler
+// warns.
+var LabelAsVar = &Check{
+	Name: "labelasvar",
+	Doc:  "C instructions using M right after an @ of a label",
+	Run: func(p *Pass) {
+		var found diag.List
+		assembler.CheckLabelUse(p.Program, p.Symbols, &found)
+		for _, d := range found {
+			p.Report(d)
+		}
+	},
+}
+
+// ComputedJump finds jumps whose target isn't a label or constant.
+var ComputedJump = &Check{
+	Name: "computedjump",
+	Doc:  "jumps to an A value loaded from a variable rather than a label",
+	Run: func(p *Pass) {
+		for _, n := range p.CFG.Nodes {
+			if n.Jump == NoJump {
+				continue
+			}
+			switch n.A.Kind {
+			case Computed:
+				p.Report(diag.Warnf(n.Pos, diag.ComputedJump, "jump target computed at %v, not a label", n.A.Pos))
+			case Loaded:
+				if sym, ok := p.Symbols.Lookup(n.A.Symbol); ok && sym.Kind == assembler.Variable {
+					p.Report(diag.Warnf(n.Pos, diag.ComputedJump, "jump target %s is a variable, not a label", sym.Name))
+				}
+			}
+		}
+	},
+}
@@ -0,0 +1,157 @@
+package vet
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/stretchr/testify/assert"
+)
+
+func assemble(t *testing.T, src string) (command.Program, *assembler.SymbolTable) {
+	tokens, err := lex.TokenizeFile("", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	program, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	_, symbols, err := assembler.Assemble(program, nil)
+	assert.NoError(t, err)
+	return program, symbols
+}
+
+// check runs one check over src, returning its warnings
+func check(t *testing.T, c *Check, src string) []string {
+	program, symbols := assemble(t, src)
+	var diags diag.List
+	Run(program, symbols, []*Check{c}, &diags)
+	var result []string
+	for _, d := range diags {
+		result = append(result, d.Error())
+	}
+	return result
+}
+
+// sum adds 1..R0 into R1 without mistakes
+const sum = `
+@i
+M=1
+@R1
+M=0
+(LOOP)
+@i
+D=M
+@R0
+D=D-M
+@END
+D;JGT
+@i
+D=M
+@R1
+M=D+M
+@i
+M=M+1
+@LOOP
+0;JMP
+(END)
+@END
+0;JMP
+`
+
+func TestClean(t *testing.T) {
+	program, symbols := assemble(t, sum)
+	var diags diag.List
+	Run(program, symbols, Checks, &diags)
+	assert.Empty(t, diags)
+}
+
+func TestCFG(t *testing.T) {
+	program, symbols := assemble(t, sum)
+	g := Build(program, symbols)
+	assert.Len(t, g.Nodes, 20)
+	assert.Equal(t, map[int][]string{4: {"LOOP"}, 18: {"END"}}, g.Labels)
+	assert.Equal(t, []int{10, 18}, g.Nodes[9].Succ)
+	assert.Equal(t, MayJump, g.Nodes[9].Jump)
+	assert.Equal(t, []int{4}, g.Nodes[17].Succ)
+	assert.Equal(t, Always, g.Nodes[17].Jump)
+	assert.Equal(t, AValue{Kind: Loaded, Symbol: "i", Value: 16, Pos: g.Nodes[0].Pos}, g.Nodes[1].A)
+	// the label starts a new block
+	assert.Equal(t, AValue{}, g.Nodes[4].A)
+
+	reachable := g.Reachable()
+	for addr, r := range reachable[:len(g.Nodes)] {
+		assert.True(t, r, "%d", addr)
+	}
+	assert.False(t, reachable[len(g.Nodes)])
+}
+
+func TestUnusedLabel(t *testing.T) {
+	assert.Equal(t, []string{"2:1: warning[W002]: label SKIP is never used"},
+		check(t, UnusedLabel, "@1\n(SKIP)\n(END)\n@END\n0;JMP\n"))
+}
+
+func TestUnreadVar(t *testing.T) {
+	assert.Equal(t, []string{"1:1: warning[W003]: variable x is written but never read"},
+		check(t, UnreadVar, "@x\nM=1\n@y\nM=1\nD=M\n@p\nD=A\n@q\nD=M\n"))
+}
+
+func TestUninitVar(t *testing.T) {
+	// y is only written on one path to its read
+	src := `@x
+M=1
+@R0
+D=M
+@SKIP
+D;JEQ
+@y
+M=1
+(SKIP)
+@x
+D=M
+@y
+D=D+M
+@z
+M=M+1
+@p
+D=A
+@p
+D=M
+`
+	assert.Equal(t, []string{
+		"12:1: warning[W004]: variable y may be read before it is written",
+		"14:1: warning[W004]: variable z may be read before it is written",
+	}, check(t, UninitVar, src))
+}
+
+func TestUnreachable(t *testing.T) {
+	src := "@R0\nD=M\n@SKIP\n0;JMP\nD=1\n(SKIP)\n@END\n0;JEQ\nD=0\n(END)\n@END\n0;JMP\n"
+	assert.Equal(t, []string{
+		"5:1: warning[W005]: unreachable code",
+		"9:1: warning[W005]: unreachable code",
+	}, check(t, Unreachable, src))
+}
+
+func TestNoHalt(t *testing.T) {
+	assert.Equal(t, []string{"3:1: warning[W006]: execution can run past the end of the program, end it with a loop such as (END) @END 0;JMP"},
+		check(t, NoHalt, "@1\nD=A\nM=D\n"))
+	assert.Empty(t, check(t, NoHalt, "(END)\n@END\n0;JMP\n"))
+	assert.NotEmpty(t, check(t, NoHalt, "(LOOP)\n@LOOP\nD;JGT\n"))
+}
+
+func TestLabelAsVar(t *testing.T) {
+	assert.Equal(t, []string{"2:1: warning[W001]: LOOP is a label defined at 1:1, but is used here as a variable"},
+		check(t, LabelAsVar, "(LOOP)\n@LOOP\nD=M\n@LOOP\n0;JMP\n"))
+	assert.Equal(t, []string{
+		"1:1: warning[W001]: i is a label defined at 5:1, but is used here as a variable",
+		"6:1: warning[W001]: i is a label defined at 5:1, but is used here as a variable",
+	}, check(t, LabelAsVar, "@i\nM=1\n@i\n0;JMP\n(i)\n@i\nD=M\n"))
+}
+
+func TestComputedJump(t *testing.T) {
+	assert.Equal(t, []string{
+		"3:1: warning[W007]: jump target ret is a variable, not a label",
+		"6:1: warning[W007]: jump target computed at 5:1, not a label",
+	}, check(t, ComputedJump, "@ret\nM=0\n0;JMP\n@ret\nA=M\n0;JMP\n"))
+}
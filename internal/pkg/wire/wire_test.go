@@ -0,0 +1,47 @@
+package wire
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	assert.NoError(t, w.Write(map[string]int{"seq": 1}))
+	assert.NoError(t, w.Write([]string{"é<"}))
+	assert.Equal(t, "Content-Length: 9\r\n\r\n{\"seq\":1}Content-Length: 7\r\n\r\n[\"é<\"]", buf.String())
+
+	r := NewReader(&buf)
+	body, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"seq":1}`, string(body))
+	body, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, `["é<"]`, string(body))
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestReadHeaders(t *testing.T) {
+	r := NewReader(strings.NewReader("content-length: 2\r\nContent-Type: application/vscode-jsonrpc\r\n\r\n{}"))
+	body, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", string(body))
+
+	for _, s := range []string{
+		"Content-Type: x\r\n\r\n{}",
+		"Content-Length: x\r\n\r\n{}",
+		"Content-Length: 5\r\n\r\n{}",
+		"bogus\r\n\r\n",
+		"Content-Length: 2\r\n",
+	} {
+		_, err := NewReader(strings.NewReader(s)).Read()
+		assert.Error(t, err, s)
+		assert.NotEqual(t, io.EOF, err, s)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"strings"
 	"testing"
 
@@ -8,8 +9,15 @@ import (
 
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/assembler"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/command"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/cpu"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/diag"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/disasm"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/hack"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/lex"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/link"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/object"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/parser"
+	"github.com/jeffgreenca/n2t-asm/internal/pkg/preproc"
 	"github.com/jeffgreenca/n2t-asm/internal/pkg/token"
 )
 
@@ -40,6 +48,32 @@ const (
 		0;JMP
 `
 
+	progSum = `
+	// sum 1..100
+		@i
+		M=1
+		@sum
+		M=0
+	(LOOP)
+		@i
+		D=M
+		@100
+		D=D-A
+		@END
+		D;JGT
+		@i
+		D=M
+		@sum
+		M=D+M
+		@i
+		AM=M+1
+		@LOOP
+		0;JMP
+	(END)
+		@END
+		0;JMP
+`
+
 	// generated by hand via nand2tetris provided assembler
 	progAddExpected = `0000000000000010
 1110110000010000
@@ -61,7 +95,7 @@ func TestLexParseSingle(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Len(t, tokens, 3)
 
-	prog, err := parser.Parse(tokens)
+	prog, err := parser.Parse(tokens, nil)
 	assert.NoError(t, err)
 	assert.Len(t, prog, 1)
 }
@@ -74,11 +108,11 @@ func TestLexParseAssembleNoLabels(t *testing.T) {
 		tokens = append(tokens, tk...)
 	}
 
-	prog, err := parser.Parse(tokens)
+	prog, err := parser.Parse(tokens, nil)
 	assert.NoError(t, err)
 	assert.Len(t, prog, 4)
 
-	hack, err := assembler.Assemble(prog)
+	hack, _, err := assembler.Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, progTrivialExpected, strings.Join(hack, "\n"))
 }
@@ -91,11 +125,11 @@ func TestAll(t *testing.T) {
 		tokens = append(tokens, tk...)
 	}
 
-	prog, err := parser.Parse(tokens)
+	prog, err := parser.Parse(tokens, nil)
 	assert.NoError(t, err)
 	assert.Len(t, prog, 9)
 
-	hack, err := assembler.Assemble(prog)
+	hack, _, err := assembler.Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, progAddExpected, strings.Join(hack, "\n"))
 }
@@ -106,16 +140,276 @@ func TestLexParseDMJGT(t *testing.T) {
 	tokens, err := tokenize("D;JGT")
 	assert.NoError(t, err)
 	assert.Len(t, tokens, 3)
-	assert.Equal(t, token.Token{Value: "D", Type: token.LOCATION}, tokens[0])
-	assert.Equal(t, token.Token{Value: "JGT", Type: token.JUMP}, tokens[1])
-	assert.Equal(t, token.Token{Type: token.END}, tokens[2])
+	assert.Equal(t, token.Token{Value: "D", Type: token.LOCATION, Pos: token.Pos{Line: 1, Col: 1}}, tokens[0])
+	assert.Equal(t, token.Token{Value: "JGT", Type: token.JUMP, Pos: token.Pos{Line: 1, Col: 3}}, tokens[1])
+	assert.Equal(t, token.Token{Type: token.END, Pos: token.Pos{Line: 1, Col: 6}}, tokens[2])
 
-	prog, err := parser.Parse(tokens)
+	prog, err := parser.Parse(tokens, nil)
 	assert.NoError(t, err)
 	assert.Len(t, prog, 1)
-	assert.Equal(t, command.C{D: command.Dest{}, C: "D", J: "JGT"}, prog[0])
+	assert.Equal(t, command.C{D: command.Dest{}, C: "D", J: "JGT", Pos: token.Pos{Line: 1, Col: 1}}, prog[0])
 
-	hack, err := assembler.Assemble(prog)
+	hack, _, err := assembler.Assemble(prog, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, "1110001100000001", hack[0])
 }
+
+func TestErrorPositions(t *testing.T) {
+	testCases := map[string]string{
+		"@1\n  D=M+1;JMP\n  D=M+&": "prog.asm:3:3: error[E002]: unknown computation: M+& (did you mean M+1?)",
+		"@1\n\n   D=X":             "prog.asm:3:6: error[E001]: unexpected rune 'X' in: D=X",
+		"(LOOP\n":                  "prog.asm:1:1: error[E001]: malformed label: (LOOP",
+	}
+	for src, expected := range testCases {
+		var diags diag.List
+		tokens, _ := lex.TokenizeFile("prog.asm", strings.NewReader(src), &diags)
+		prog, _ := parser.Parse(tokens, &diags)
+		_, _, err := assembler.Assemble(prog, &diags)
+		assert.EqualError(t, err, expected)
+	}
+}
+
+func TestReportsEveryError(t *testing.T) {
+	src := "@1\nD=X\n@2\nD=M+&\n(LOOP\n@3\nAM=D|A\n"
+
+	var diags diag.List
+	tokens, err := lex.TokenizeFile("prog.asm", strings.NewReader(src), &diags)
+	assert.Error(t, err)
+	prog, err := parser.Parse(tokens, &diags)
+	assert.Error(t, err)
+	assert.Len(t, prog, 5)
+	hack, _, err := assembler.Assemble(prog, &diags)
+	assert.Error(t, err)
+	assert.Empty(t, hack)
+
+	diags.Sort()
+	assert.Equal(t, `prog.asm:2:3: error[E001]: unexpected rune 'X' in: D=X
+prog.asm:4:1: error[E002]: unknown computation: M+& (did you mean M+1?)
+prog.asm:5:1: error[E001]: malformed label: (LOOP`, diags.Error())
+}
+
+func TestRejectsInvalidCInstructions(t *testing.T) {
+	testCases := map[string]string{
+		"0;JPM":      "1:3: error[E003]: unknown jump 'JPM' in: 0;JPM (did you mean JMP?)",
+		"D;":         "1:3: error[E003]: unknown jump '' in: D;",
+		"MX=D":       "1:2: error[E004]: unknown destination 'X' in: MX=D",
+		"MM=D":       "1:2: error[E004]: duplicate destination M",
+		"AMD=":       "1:1: error[E005]: missing computation",
+		"D=;JMP":     "1:1: error[E005]: missing computation",
+		";JMP":       "1:2: error[E005]: missing computation",
+		"D=alu(q=1)": "1:1: error[E002]: bad raw computation: unknown alu flag q, must be one of a, zx, nx, zy, ny, f, no",
+		"D=%12":      "1:1: error[E002]: bad raw computation: control bits %12 must be 7 binary digits",
+	}
+	for src, expected := range testCases {
+		var diags diag.List
+		tokens, _ := lex.TokenizeFile("", strings.NewReader(src), &diags)
+		prog, _ := parser.Parse(tokens, &diags)
+		_, _, err := assembler.Assemble(prog, &diags)
+		assert.EqualError(t, err, expected, src)
+	}
+}
+
+func TestRejectsOutOfRangeAddress(t *testing.T) {
+	for _, src := range []string{"@32768", "@40000", "@99999999999999999999", "@0x8000", "@0b1000000000000000"} {
+		var diags diag.List
+		tokens, _ := lex.TokenizeFile("", strings.NewReader(src), &diags)
+		_, err := parser.Parse(tokens, &diags)
+		assert.EqualError(t, err, "1:2: error[E006]: address "+src[1:]+" out of range, must be 0..32767")
+	}
+}
+
+// assemble source, returning the machine words and symbol table
+func assemble(t *testing.T, src string) ([]uint16, *assembler.SymbolTable) {
+	tokens, err := lex.Tokenize(strings.NewReader(src))
+	assert.NoError(t, err)
+	prog, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	text, symbols, err := assembler.Assemble(prog, nil)
+	assert.NoError(t, err)
+	words, err := hack.Words(text)
+	assert.NoError(t, err)
+	return words, symbols
+}
+
+func TestDisassembleRoundTrip(t *testing.T) {
+	for _, src := range []string{progTrivial, progAdd, progSum} {
+		words, symbols := assemble(t, src)
+
+		var sym bytes.Buffer
+		assert.NoError(t, symbols.WriteText(&sym))
+		restored, err := assembler.ReadText(&sym)
+		assert.NoError(t, err)
+
+		for _, s := range []*assembler.SymbolTable{nil, restored} {
+			program, err := disasm.Disassemble(words, s)
+			assert.NoError(t, err)
+			var asm bytes.Buffer
+			assert.NoError(t, disasm.Write(&asm, program))
+
+			again, _ := assemble(t, asm.String())
+			assert.Equal(t, words, again)
+		}
+	}
+}
+
+func TestRawCompRoundTrip(t *testing.T) {
+	words, _ := assemble(t, "@7\nD=%0110000\nAM=alu(a=1,no=1);JNE\nD=alu(zx=1,nx=1,zy=1);JMP\n")
+	program, err := disasm.Disassemble(words, nil)
+	assert.NoError(t, err)
+	var asm bytes.Buffer
+	assert.NoError(t, disasm.Write(&asm, program))
+	assert.Equal(t, "    @7\n    D=A\n    AM=%1000001;JNE\n    D=%0111000;JMP\n", asm.String())
+
+	again, _ := assemble(t, asm.String())
+	assert.Equal(t, words, again)
+}
+
+func TestRunOnCPU(t *testing.T) {
+	words, symbols := assemble(t, progSum)
+	c, err := cpu.New(words)
+	assert.NoError(t, err)
+	assert.True(t, c.Run(10000))
+
+	sum, _ := symbols.Lookup("sum")
+	end, _ := symbols.Lookup("END")
+	assert.Equal(t, uint16(5050), c.RAM[sum.Value])
+	assert.Equal(t, uint16(end.Value+1), c.PC)
+
+	words, _ = assemble(t, progAdd)
+	c, _ = cpu.New(words)
+	assert.True(t, c.Run(100))
+	assert.Equal(t, uint16(5), c.RAM[0])
+}
+
+func TestMacrosRunOnCPU(t *testing.T) {
+	src := `
+.macro PUSH_CONST n
+    @n
+    D=A
+    @SP
+    AM=M+1
+    A=A-1
+    M=D
+.endm
+.macro SUM
+    @SP
+    AM=M-1
+    D=M
+    A=A-1
+    M=D+M
+.endm
+    @256
+    D=A
+    @SP
+    M=D
+    PUSH_CONST 7
+    PUSH_CONST 8
+    SUM
+(END)
+    @END
+    0;JMP
+`
+	tokens, err := preproc.TokenizeFile("", strings.NewReader(src), nil)
+	assert.NoError(t, err)
+	prog, err := parser.Parse(tokens, nil)
+	assert.NoError(t, err)
+	text, _, err := assembler.Assemble(prog, nil)
+	assert.NoError(t, err)
+	words, err := hack.Words(text)
+	assert.NoError(t, err)
+
+	c, err := cpu.New(words)
+	assert.NoError(t, err)
+	assert.True(t, c.Run(1000))
+	assert.Equal(t, uint16(257), c.RAM[0])
+	assert.Equal(t, uint16(15), c.RAM[256])
+}
+
+func TestLiteralsRunOnCPU(t *testing.T) {
+	// stores 'A'+1 in R0, and the keyboard code of newline and 0x4000|0b1010 in R1 and R2
+	words, _ := assemble(t, `.equ NL '\n'
+    @'A'+1
+    D=A
+    @R0
+    M=D
+    @NL
+    D=A
+    @R1
+    M=D
+    @0x4000|0b1010
+    D=A
+    @0b10
+    M=D
+(END)
+    @END
+    0;JMP
+`)
+	c, err := cpu.New(words)
+	assert.NoError(t, err)
+	assert.True(t, c.Run(100))
+	assert.Equal(t, uint16('B'), c.RAM[0])
+	assert.Equal(t, uint16(128), c.RAM[1])
+	assert.Equal(t, uint16(0x400A), c.RAM[2])
+}
+
+func TestLinkRunOnCPU(t *testing.T) {
+	// main calls INC in inc, returning through R13, and both use a private x
+	modules := map[string]string{
+		"main": `
+.import INC
+    @5
+    D=A
+    @x
+    M=D
+    @BACK
+    D=A
+    @R13
+    M=D
+    @INC
+    0;JMP
+(BACK)
+    @x
+    D=M
+    @R1
+    M=D
+(END)
+    @END
+    0;JMP
+`,
+		"inc": `
+.export INC
+(INC)
+    @R0
+    M=M+1
+    @x
+    M=1
+    @R13
+    A=M
+    0;JMP
+`,
+	}
+	var objects []*object.Object
+	for _, name := range []string{"main", "inc"} {
+		tokens, err := preproc.TokenizeFile(name+".asm", strings.NewReader(modules[name]), nil)
+		assert.NoError(t, err)
+		prog, err := parser.Parse(tokens, nil)
+		assert.NoError(t, err)
+		o, err := assembler.Options{}.Compile(name, prog, nil)
+		assert.NoError(t, err)
+		objects = append(objects, o)
+	}
+	text, symbols, err := link.Link(objects, nil)
+	assert.NoError(t, err)
+	words, err := hack.Words(text)
+	assert.NoError(t, err)
+
+	c, err := cpu.New(words)
+	assert.NoError(t, err)
+	assert.True(t, c.Run(1000))
+	end, _ := symbols.Lookup("main:END")
+	assert.Equal(t, uint16(end.Value+1), c.PC)
+	assert.Equal(t, uint16(1), c.RAM[0])
+	assert.Equal(t, uint16(5), c.RAM[1])
+	assert.Equal(t, uint16(5), c.RAM[16])
+	assert.Equal(t, uint16(1), c.RAM[17])
+}